@@ -3,19 +3,73 @@ package main
 import (
 	"context"
 	"errors"
+	"os"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/charmbracelet/ssh"
+	"github.com/frikkfelix/sshchat/go/pkg/config"
 	"github.com/frikkfelix/sshchat/go/pkg/core"
 	"github.com/frikkfelix/sshchat/go/pkg/server"
 )
 
 func main() {
-	hub := core.NewHub()
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	var store core.Store = core.NewMemoryStore(cfg.History.Memory)
+	if path := cfg.DataPath("messages.log"); path != "" {
+		store, err = core.OpenFileStore(path)
+		if err != nil {
+			log.Fatalf("failed to open message store: %v", err)
+		}
+	}
+
+	registry, err := core.OpenRegistry(cfg.DataPath("nicks.json"))
+	if err != nil {
+		log.Fatalf("failed to open nickname registry: %v", err)
+	}
+
+	invites, err := core.OpenInvites(cfg.DataPath("invites.json"))
+	if err != nil {
+		log.Fatalf("failed to open invites: %v", err)
+	}
+
+	mailbox, err := core.OpenMailbox(cfg.DataPath("mail.json"))
+	if err != nil {
+		log.Fatalf("failed to open mailbox: %v", err)
+	}
+
+	policy, err := server.NewAuthPolicy(cfg, invites)
+	if err != nil {
+		log.Fatalf("failed to set up authentication: %v", err)
+	}
+
+	opts := []core.HubOption{
+		core.WithStore(store),
+		core.WithRegistry(registry),
+		core.WithInvites(invites),
+		core.WithMailbox(mailbox),
+		core.WithChannelState(cfg.DataPath("channels.json")),
+		core.WithGlobalBans(cfg.DataPath("bans.json")),
+		core.WithAdmins(cfg.Admins),
+		core.WithMOTD(cfg.MOTD),
+		core.WithHistoryReplay(cfg.History.Replay),
+		core.WithChannelPolicy(core.CreatePolicy(cfg.Create.Policy), cfg.Create.ExpireEmpty),
+		core.WithIdleAfter(cfg.Presence.IdleAfter),
+		core.WithLastSeen(cfg.DataPath("seen.json")),
+		core.WithPreferences(cfg.DataPath("prefs.json")),
+	}
+	for _, ch := range cfg.Channels {
+		opts = append(opts, core.WithDefaultChannel(ch.Name, ch.Topic, ch.Owner))
+	}
+
+	hub := core.NewHub(opts...)
 	go hub.Run()
 
-	srv, err := server.New(hub)
+	srv, err := server.New(hub, cfg, policy)
 	if err != nil {
 		log.Fatalf("failed to create server: %v", err)
 	}
@@ -28,7 +82,7 @@ func main() {
 		}
 	}()
 
-	server.WaitForShutdown()
+	server.WaitForShutdown(hub.ShutdownRequested())
 	log.Info("Shutting down...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -39,4 +93,7 @@ func main() {
 
 	hub.Shutdown()
 
+	if err := store.Close(); err != nil {
+		log.Error("Message store close error:", err)
+	}
 }
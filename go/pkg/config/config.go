@@ -0,0 +1,300 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+const (
+	AuthOpen      = "open"
+	AuthPublicKey = "publickey"
+	AuthAllowlist = "allowlist"
+)
+
+const (
+	CreateOnJoin   = "join"
+	CreateExplicit = "command"
+	CreateAdmin    = "admin"
+)
+
+type Config struct {
+	Listen   string          `toml:"listen"`
+	DataDir  string          `toml:"data_dir"`
+	HostKeys []string        `toml:"host_keys"`
+	MOTD     string          `toml:"motd"`
+	Admins   []string        `toml:"admins"`
+	Timeout  time.Duration   `toml:"timeout"`
+	Auth     AuthConfig      `toml:"auth"`
+	History  HistoryConfig   `toml:"history"`
+	Create   CreateConfig    `toml:"create"`
+	Presence PresenceConfig  `toml:"presence"`
+	Channels []ChannelConfig `toml:"channels"`
+}
+
+type AuthConfig struct {
+	Mode string `toml:"mode"`
+	// AuthorizedKeys is the allowlist read in allowlist mode, in OpenSSH
+	// authorized_keys format.
+	AuthorizedKeys string `toml:"authorized_keys"`
+}
+
+type HistoryConfig struct {
+	// Replay is how many messages a client receives when joining a channel.
+	Replay int `toml:"replay"`
+	// Memory caps per-channel history when running without a data directory.
+	Memory int `toml:"memory"`
+}
+
+type CreateConfig struct {
+	// Policy is "join" to create channels on first /join, "command" to
+	// require /create, or "admin" to let only admins /create them.
+	Policy string `toml:"policy"`
+	// ExpireEmpty removes channels created by /join once they have been
+	// empty this long. Zero keeps them.
+	ExpireEmpty time.Duration `toml:"expire_empty"`
+}
+
+type PresenceConfig struct {
+	// IdleAfter is how long a user goes without input before showing as
+	// idle. Zero turns idle detection off.
+	IdleAfter time.Duration `toml:"idle_after"`
+}
+
+type ChannelConfig struct {
+	Name  string `toml:"name"`
+	Topic string `toml:"topic"`
+	// Owner is the SHA256 fingerprint of the key that owns the channel and
+	// can appoint operators. Server admins are always operators.
+	Owner string `toml:"owner"`
+}
+
+func Default() *Config {
+	return &Config{
+		Listen:  "0.0.0.0:42069",
+		DataDir: "data",
+		Timeout: time.Minute,
+		Auth: AuthConfig{
+			Mode: AuthOpen,
+		},
+		History: HistoryConfig{
+			Replay: 20,
+			Memory: 100,
+		},
+		Create: CreateConfig{
+			Policy: CreateOnJoin,
+		},
+		Presence: PresenceConfig{
+			IdleAfter: 10 * time.Minute,
+		},
+		Channels: []ChannelConfig{
+			{Name: "general", Topic: "General discussion"},
+			{Name: "random", Topic: "Random"},
+		},
+	}
+}
+
+// Load builds the configuration from defaults, an optional TOML file,
+// SSHCHAT_* environment variables and command line flags, in increasing
+// order of precedence.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("sshchat", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("SSHCHAT_CONFIG"), "path to a TOML config file")
+	listen := fs.String("listen", "", "address to listen on, e.g. 0.0.0.0:42069")
+	dataDir := fs.String("data-dir", "", "directory for persistent state, empty to keep everything in memory")
+	hostKeys := fs.String("host-keys", "", "comma separated host key paths, generated when missing")
+	motd := fs.String("motd", "", "message of the day shown on connect")
+	authMode := fs.String("auth", "", "authentication mode: open, publickey or allowlist")
+	authorizedKeys := fs.String("authorized-keys", "", "authorized_keys file used in allowlist mode")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *path != "" {
+		// The decoder fills slices element by element, so the default
+		// channels would bleed into the configured ones.
+		defaults := cfg.Channels
+		cfg.Channels = nil
+		if _, err := toml.DecodeFile(*path, cfg); err != nil {
+			return nil, fmt.Errorf("read config %s: %w", *path, err)
+		}
+		if len(cfg.Channels) == 0 {
+			cfg.Channels = defaults
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "data-dir":
+			cfg.DataDir = *dataDir
+		case "host-keys":
+			cfg.HostKeys = splitList(*hostKeys)
+		case "motd":
+			cfg.MOTD = *motd
+		case "auth":
+			cfg.Auth.Mode = *authMode
+		case "authorized-keys":
+			cfg.Auth.AuthorizedKeys = *authorizedKeys
+		}
+	})
+
+	if cfg.Auth.AuthorizedKeys == "" {
+		cfg.Auth.AuthorizedKeys = cfg.DataPath("authorized_keys")
+	}
+	if len(cfg.HostKeys) == 0 && cfg.DataDir != "" {
+		cfg.HostKeys = []string{cfg.DataPath("ssh_host_ed25519")}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	if v, ok := os.LookupEnv("SSHCHAT_LISTEN"); ok {
+		c.Listen = v
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_DATA_DIR"); ok {
+		c.DataDir = v
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_HOST_KEYS"); ok {
+		c.HostKeys = splitList(v)
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_MOTD"); ok {
+		c.MOTD = v
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_AUTH"); ok {
+		c.Auth.Mode = v
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_AUTHORIZED_KEYS"); ok {
+		c.Auth.AuthorizedKeys = v
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_ADMINS"); ok {
+		c.Admins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("SSHCHAT_HISTORY_REPLAY"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("SSHCHAT_HISTORY_REPLAY: %w", err)
+		}
+		c.History.Replay = n
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %w", err))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		errs = append(errs, fmt.Errorf("listen: invalid port %q", port))
+	}
+
+	if c.Timeout < 0 {
+		errs = append(errs, errors.New("timeout: must not be negative"))
+	}
+
+	seenKeys := make(map[string]bool)
+	for i, path := range c.HostKeys {
+		if seenKeys[path] {
+			errs = append(errs, fmt.Errorf("host_keys[%d]: duplicate path %q", i, path))
+		}
+		seenKeys[path] = true
+	}
+
+	switch c.Auth.Mode {
+	case AuthOpen, AuthPublicKey:
+	case AuthAllowlist:
+		if c.Auth.AuthorizedKeys == "" {
+			errs = append(errs, errors.New("auth.authorized_keys: required in allowlist mode"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("auth.mode: unknown mode %q", c.Auth.Mode))
+	}
+
+	if c.History.Replay < 0 {
+		errs = append(errs, errors.New("history.replay: must not be negative"))
+	}
+	if c.History.Memory < 0 {
+		errs = append(errs, errors.New("history.memory: must not be negative"))
+	}
+
+	switch c.Create.Policy {
+	case CreateOnJoin, CreateExplicit, CreateAdmin:
+	default:
+		errs = append(errs, fmt.Errorf("create.policy: unknown policy %q", c.Create.Policy))
+	}
+	if c.Create.ExpireEmpty < 0 {
+		errs = append(errs, errors.New("create.expire_empty: must not be negative"))
+	}
+
+	if c.Presence.IdleAfter < 0 {
+		errs = append(errs, errors.New("presence.idle_after: must not be negative"))
+	}
+
+	if len(c.Channels) == 0 {
+		errs = append(errs, errors.New("channels: at least one default channel is required"))
+	}
+	seen := make(map[string]bool)
+	for i, ch := range c.Channels {
+		name := strings.TrimPrefix(ch.Name, "#")
+		if !core.ValidChannel(name) {
+			errs = append(errs, fmt.Errorf("channels[%d]: invalid name %q", i, ch.Name))
+			continue
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("channels[%d]: duplicate channel %q", i, name))
+		}
+		seen[name] = true
+		c.Channels[i].Name = name
+		if ch.Owner != "" && !strings.HasPrefix(ch.Owner, "SHA256:") {
+			errs = append(errs, fmt.Errorf("channels[%d]: owner %q is not a SHA256 key fingerprint", i, ch.Owner))
+		}
+	}
+
+	for i, fp := range c.Admins {
+		if !strings.HasPrefix(fp, "SHA256:") {
+			errs = append(errs, fmt.Errorf("admins[%d]: %q is not a SHA256 key fingerprint", i, fp))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// DataPath returns name inside the data directory, or "" when the server
+// runs without one.
+func (c *Config) DataPath(name string) string {
+	if c.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(c.DataDir, name)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
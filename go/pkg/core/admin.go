@@ -0,0 +1,237 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func (h *Hub) IsAdmin(userID string) bool {
+	return h.admins[userID]
+}
+
+// Banned reports whether fingerprint is under an active server-wide ban.
+func (h *Hub) Banned(fingerprint string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ban, ok := h.globalBans[fingerprint]
+	return ok && ban.Active(time.Now())
+}
+
+// ShutdownRequested is closed when an admin asks the server to stop.
+func (h *Hub) ShutdownRequested() <-chan struct{} {
+	return h.shutdownRequest
+}
+
+func (h *Hub) requireAdmin(session *Session) bool {
+	if !h.IsAdmin(session.UserID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return false
+	}
+	return true
+}
+
+func (h *Hub) allUsers() []*User {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make([]*User, 0, len(h.users))
+	for _, u := range h.users {
+		users = append(users, u)
+	}
+	return users
+}
+
+func (h *Hub) wall(text string) {
+	msg := systemMessage(text)
+	for _, u := range h.allUsers() {
+		u.Deliver(msg)
+	}
+}
+
+func (h *Hub) cmdWall(session *Session, text string) {
+	if !h.requireAdmin(session) {
+		return
+	}
+	h.wall(fmt.Sprintf("[wall] %s: %s", session.Username(), text))
+}
+
+func (h *Hub) cmdShutdown(session *Session, reason string) {
+	if !h.requireAdmin(session) {
+		return
+	}
+
+	log.Warn("Shutdown requested", "by", session.Username(), "reason", reason)
+	h.wall(fmt.Sprintf("The server is shutting down (requested by %s)%s", session.Username(), formatReason(reason)))
+	h.shutdownOnce.Do(func() {
+		close(h.shutdownRequest)
+	})
+}
+
+func (h *Hub) cmdKill(session *Session, target, reason string) {
+	if !h.requireAdmin(session) {
+		return
+	}
+
+	user := h.findUserByNick(target)
+	if user == nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("User %s not found", target)))
+		return
+	}
+
+	h.disconnect(user, fmt.Sprintf("You were disconnected by %s%s", session.Username(), formatReason(reason)),
+		"Killed by "+session.Username()+formatReason(reason))
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Disconnected %s", user.Username())))
+}
+
+// disconnect closes every connection of user after telling them why. reason
+// is what others see when the user quits.
+func (h *Hub) disconnect(user *User, notice, reason string) {
+	user.Deliver(errorMessage(notice))
+	for _, s := range user.Sessions() {
+		s.Quit(reason)
+	}
+}
+
+func (h *Hub) cmdInviteCode(session *Session) {
+	if !h.requireAdmin(session) {
+		return
+	}
+	if h.invites == nil {
+		h.sendToSession(session, errorMessage("Invites are not enabled on this server"))
+		return
+	}
+
+	invite, err := h.invites.Issue(session.Username())
+	if err != nil {
+		log.Error("Failed to issue invite", "err", err)
+		h.sendToSession(session, errorMessage("Could not create an invite, try again later"))
+		return
+	}
+	h.sendToSession(session, systemMessage(fmt.Sprintf(
+		"Invite code %s is valid once until %s", invite.Code, invite.ExpiresAt.Format("2006-01-02 15:04"))))
+}
+
+func (h *Hub) cmdGlobalBan(session *Session, args []string) {
+	if !h.requireAdmin(session) {
+		return
+	}
+
+	fingerprint, nick, ok := h.resolveTarget(args[0])
+	if !ok {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown user %s", args[0])))
+		return
+	}
+	if h.IsAdmin(fingerprint) {
+		h.sendToSession(session, errorMessage("Admins cannot be banned"))
+		return
+	}
+
+	ban := &Ban{
+		Fingerprint: fingerprint,
+		Nick:        nick,
+		By:          session.Username(),
+	}
+	rest := args[1:]
+	if len(rest) > 0 {
+		if d, err := parseDuration(rest[0]); err == nil {
+			ban.Until = time.Now().Add(d)
+			rest = rest[1:]
+		}
+	}
+	ban.Reason = strings.Join(rest, " ")
+
+	h.mu.Lock()
+	h.globalBans[fingerprint] = ban
+	h.saveGlobalBansLocked()
+	h.mu.Unlock()
+
+	if user := h.findUser(fingerprint); user != nil {
+		h.disconnect(user, fmt.Sprintf("You were banned from this server by %s%s", ban.By, formatReason(ban.Reason)), "Banned")
+	}
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Banned %s from the server", ban)))
+}
+
+func (h *Hub) cmdGlobalUnban(session *Session, target string) {
+	if !h.requireAdmin(session) {
+		return
+	}
+
+	fingerprint, _, ok := h.resolveTarget(target)
+
+	h.mu.Lock()
+	_, banned := h.globalBans[fingerprint]
+	if ok && banned {
+		delete(h.globalBans, fingerprint)
+		h.saveGlobalBansLocked()
+	}
+	h.mu.Unlock()
+
+	if !ok || !banned {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("%s is not banned from the server", target)))
+		return
+	}
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Lifted the server ban on %s", target)))
+}
+
+func (h *Hub) cmdGlobalBans(session *Session) {
+	if !h.requireAdmin(session) {
+		return
+	}
+
+	h.mu.RLock()
+	now := time.Now()
+	var lines []string
+	for _, ban := range h.globalBans {
+		if ban.Active(now) {
+			lines = append(lines, ban.String())
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(lines) == 0 {
+		h.sendToSession(session, systemMessage("No server bans"))
+		return
+	}
+	sort.Strings(lines)
+	h.sendToSession(session, systemMessage("Server bans:\n"+strings.Join(lines, "\n")))
+}
+
+func (h *Hub) loadGlobalBans() {
+	if h.bansPath == "" {
+		return
+	}
+
+	var bans []*Ban
+	if err := readJSONFile(h.bansPath, &bans); err != nil {
+		log.Error("Failed to load server bans", "path", h.bansPath, "err", err)
+		return
+	}
+	for _, ban := range bans {
+		h.globalBans[ban.Fingerprint] = ban
+	}
+}
+
+// saveGlobalBansLocked persists server bans. Callers must hold h.mu.
+func (h *Hub) saveGlobalBansLocked() {
+	if h.bansPath == "" {
+		return
+	}
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(h.globalBans))
+	for _, ban := range h.globalBans {
+		if ban.Active(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+	if err := writeJSONFile(h.bansPath, bans); err != nil {
+		log.Error("Failed to save server bans", "path", h.bansPath, "err", err)
+	}
+}
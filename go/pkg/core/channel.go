@@ -2,76 +2,197 @@ package core
 
 import (
 	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
 )
 
 type Channel struct {
-	Name     string
-	Topic    string
-	sessions map[string]*Session
-	history  []*Message
-	mu       sync.RWMutex
+	Name        string
+	topic       string
+	topicBy     string
+	topicAt     time.Time
+	description string
+	modes       Modes
+	createdBy   string
+	createdAt   time.Time
+	ephemeral   bool
+	archived    bool
+	emptySince  time.Time
+	members     map[string]*User
+	roles       map[string]Role
+	bans        map[string]*Ban
+	mutes       map[string]time.Time
+	invites     map[string]string
+	store       Store
+	mu          sync.RWMutex
+}
+
+// channelRecord is the persisted form of a channel's metadata and
+// moderation state. Membership is not persisted.
+type channelRecord struct {
+	Name        string               `json:"name"`
+	Topic       string               `json:"topic,omitempty"`
+	TopicBy     string               `json:"topic_by,omitempty"`
+	TopicAt     time.Time            `json:"topic_at,omitzero"`
+	Description string               `json:"description,omitempty"`
+	Modes       Modes                `json:"modes,omitzero"`
+	CreatedBy   string               `json:"created_by,omitempty"`
+	CreatedAt   time.Time            `json:"created_at,omitzero"`
+	Ephemeral   bool                 `json:"ephemeral,omitempty"`
+	Archived    bool                 `json:"archived,omitempty"`
+	Roles       map[string]Role      `json:"roles,omitempty"`
+	Bans        []*Ban               `json:"bans,omitempty"`
+	Mutes       map[string]time.Time `json:"mutes,omitempty"`
+	Invites     map[string]string    `json:"invites,omitempty"`
 }
 
-func NewChannel(name, topic string) *Channel {
+func NewChannel(name, topic string, store Store) *Channel {
 	return &Channel{
-		Name:     name,
-		Topic:    topic,
-		sessions: make(map[string]*Session),
-		history:  make([]*Message, 0, 100),
+		Name:      name,
+		topic:     topic,
+		createdAt: time.Now(),
+		members:   make(map[string]*User),
+		roles:     make(map[string]Role),
+		bans:      make(map[string]*Ban),
+		mutes:     make(map[string]time.Time),
+		invites:   make(map[string]string),
+		store:     store,
 	}
 }
 
-func (c *Channel) AddSession(session *Session) {
-	c.mu.Lock()
-	c.sessions[session.ID] = session
-	c.mu.Unlock()
+func (c *Channel) record() channelRecord {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rec := channelRecord{
+		Name:        c.Name,
+		Topic:       c.topic,
+		TopicBy:     c.topicBy,
+		TopicAt:     c.topicAt,
+		Description: c.description,
+		Modes:       c.modes,
+		CreatedBy:   c.createdBy,
+		CreatedAt:   c.createdAt,
+		Ephemeral:   c.ephemeral,
+		Archived:    c.archived,
+		Roles:       make(map[string]Role, len(c.roles)),
+		Mutes:       make(map[string]time.Time, len(c.mutes)),
+		Invites:     make(map[string]string, len(c.invites)),
+	}
+	for id, role := range c.roles {
+		rec.Roles[id] = role
+	}
+	now := time.Now()
+	for _, ban := range c.bans {
+		if ban.Active(now) {
+			rec.Bans = append(rec.Bans, ban)
+		}
+	}
+	for id, until := range c.mutes {
+		rec.Mutes[id] = until
+	}
+	for id, by := range c.invites {
+		rec.Invites[id] = by
+	}
+	return rec
 }
 
-func (c *Channel) RemoveSession(sessionID string) {
+func (c *Channel) restore(rec channelRecord) {
 	c.mu.Lock()
-	delete(c.sessions, sessionID)
-	c.mu.Unlock()
+	defer c.mu.Unlock()
+
+	c.topic, c.topicBy, c.topicAt = rec.Topic, rec.TopicBy, rec.TopicAt
+	c.description = rec.Description
+	c.modes = rec.Modes
+	c.createdBy = rec.CreatedBy
+	if !rec.CreatedAt.IsZero() {
+		c.createdAt = rec.CreatedAt
+	}
+	c.ephemeral = rec.Ephemeral
+	c.archived = rec.Archived
+	for id, role := range rec.Roles {
+		c.roles[id] = role
+	}
+	for _, ban := range rec.Bans {
+		c.bans[ban.Fingerprint] = ban
+	}
+	for id, until := range rec.Mutes {
+		c.mutes[id] = until
+	}
+	for id, by := range rec.Invites {
+		c.invites[id] = by
+	}
 }
 
-func (c *Channel) Broadcast(msg *Message, allSessions map[string]*Session) {
+func (c *Channel) AddMember(user *User) error {
 	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	c.history = append(c.history, msg)
-	if len(c.history) > 100 {
-		c.history = c.history[1:]
+	if ban, ok := c.bans[user.ID]; ok && ban.Active(time.Now()) {
+		return ErrBanned
 	}
+	c.members[user.ID] = user
+	return nil
+}
+
+func (c *Channel) RemoveMember(userID string) {
+	c.mu.Lock()
+	delete(c.members, userID)
 	c.mu.Unlock()
+}
 
+func (c *Channel) HasMember(userID string) bool {
 	c.mu.RLock()
-	sessionIDs := make([]string, 0, len(c.sessions))
-	for id := range c.sessions {
-		sessionIDs = append(sessionIDs, id)
+	defer c.mu.RUnlock()
+	_, ok := c.members[userID]
+	return ok
+}
+
+func (c *Channel) Members() []*User {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	members := make([]*User, 0, len(c.members))
+	for _, u := range c.members {
+		members = append(members, u)
 	}
-	c.mu.RUnlock()
+	return members
+}
 
-	for _, id := range sessionIDs {
-		if session, ok := allSessions[id]; ok {
-			session.EnqueueOutbound(msg)
-		}
+func (c *Channel) Broadcast(msg *Message) {
+	if err := c.store.Append(msg); err != nil {
+		log.Error("Failed to store message", "channel", c.Name, "err", err)
 	}
+
+	c.Send(msg)
 }
 
-func (c *Channel) GetRecentHistory(limit int) []*Message {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+// Send delivers msg to every member without storing it.
+func (c *Channel) Send(msg *Message) {
+	for _, member := range c.Members() {
+		member.Deliver(msg)
+	}
+}
 
-	start := 0
-	if len(c.history) > limit {
-		start = len(c.history) - limit
+// Refresh tells every member's connections that the member list changed.
+func (c *Channel) Refresh() {
+	for _, member := range c.Members() {
+		member.Refresh()
 	}
+}
 
-	result := make([]*Message, len(c.history)-start)
-	copy(result, c.history[start:])
-	return result
+func (c *Channel) GetRecentHistory(limit int) []*Message {
+	history, err := c.store.RangeChannel(c.Name, time.Time{}, limit)
+	if err != nil {
+		log.Error("Failed to load history", "channel", c.Name, "err", err)
+		return nil
+	}
+	return history
 }
 
 func (c *Channel) UserCount() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return len(c.sessions)
+	return len(c.members)
 }
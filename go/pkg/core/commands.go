@@ -1,8 +1,11 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+
+	"github.com/charmbracelet/log"
 )
 
 type Command struct {
@@ -16,8 +19,34 @@ func (h *Hub) executeCommand(session *Session, cmd Command) {
 		h.cmdHelp(session)
 	case "join", "j":
 		if len(cmd.Args) > 0 {
-			h.cmdJoin(session, cmd.Args[0])
+			h.cmdJoin(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "part", "leave":
+		channel := session.CurrentChannel
+		if len(cmd.Args) > 0 {
+			channel = cmd.Args[0]
+		}
+		h.partChannel(session, channel)
+	case "topic":
+		h.cmdTopic(session, strings.Join(cmd.Args, " "))
+	case "mode":
+		h.cmdMode(session, cmd.Args)
+	case "description", "desc":
+		h.cmdDescription(session, strings.Join(cmd.Args, " "))
+	case "invite":
+		if len(cmd.Args) > 0 {
+			h.cmdInvite(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "uninvite":
+		if len(cmd.Args) > 0 {
+			h.cmdUninvite(session, cmd.Args[0])
+		}
+	case "info":
+		name := ""
+		if len(cmd.Args) > 0 {
+			name = cmd.Args[0]
 		}
+		h.cmdInfo(session, name)
 	case "list", "channels":
 		h.cmdListChannels(session)
 	case "users", "who":
@@ -26,39 +55,213 @@ func (h *Hub) executeCommand(session *Session, cmd Command) {
 		if len(cmd.Args) >= 2 {
 			h.cmdDirectMessage(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
 		}
+	case "query":
+		if len(cmd.Args) > 0 {
+			h.cmdQuery(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "reply":
+		if len(cmd.Args) >= 2 {
+			h.cmdReply(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "thread":
+		if len(cmd.Args) > 0 {
+			h.cmdThread(session, cmd.Args[0])
+		}
+	case "react":
+		if len(cmd.Args) >= 2 {
+			h.cmdReact(session, cmd.Args[0], cmd.Args[1])
+		}
+	case "edit":
+		if len(cmd.Args) >= 2 {
+			h.cmdEdit(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "delete":
+		if len(cmd.Args) > 0 && strings.HasPrefix(cmd.Args[0], "#") {
+			h.cmdDeleteChannel(session, cmd.Args[0])
+		} else if len(cmd.Args) > 0 {
+			h.cmdDelete(session, cmd.Args[0])
+		}
+	case "create":
+		if len(cmd.Args) > 0 {
+			h.cmdCreate(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "archive", "unarchive":
+		name := ""
+		if len(cmd.Args) > 0 {
+			name = cmd.Args[0]
+		}
+		h.cmdArchive(session, name, cmd.Name == "archive")
+	case "search":
+		if len(cmd.Args) > 0 {
+			h.cmdSearch(session, strings.Join(cmd.Args, " "))
+		}
+	case "mentions":
+		h.cmdMentions(session, cmd.Args)
+	case "mail":
+		h.cmdMail(session, cmd.Args)
+	case "away":
+		h.cmdAway(session, strings.Join(cmd.Args, " "))
+	case "back":
+		h.cmdBack(session)
+	case "register":
+		h.cmdRegister(session)
+	case "nick":
+		if len(cmd.Args) > 0 {
+			h.cmdNick(session, cmd.Args[0])
+		}
+	case "whois":
+		if len(cmd.Args) > 0 {
+			h.cmdWhois(session, cmd.Args[0])
+		}
+	case "kick":
+		if len(cmd.Args) > 0 {
+			h.cmdKick(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "ban":
+		if len(cmd.Args) > 0 {
+			h.cmdBan(session, cmd.Args)
+		}
+	case "unban":
+		if len(cmd.Args) > 0 {
+			h.cmdUnban(session, cmd.Args[0])
+		}
+	case "bans":
+		h.cmdBans(session)
+	case "mute":
+		if len(cmd.Args) > 0 {
+			h.cmdMute(session, cmd.Args)
+		}
+	case "unmute":
+		if len(cmd.Args) > 0 {
+			h.cmdUnmute(session, cmd.Args[0])
+		}
+	case "op":
+		if len(cmd.Args) > 0 {
+			h.cmdSetRole(session, cmd.Args[0], RoleOperator)
+		}
+	case "deop", "devoice":
+		if len(cmd.Args) > 0 {
+			h.cmdSetRole(session, cmd.Args[0], RoleMember)
+		}
+	case "voice":
+		if len(cmd.Args) > 0 {
+			h.cmdSetRole(session, cmd.Args[0], RoleVoiced)
+		}
+	case "wall":
+		if len(cmd.Args) > 0 {
+			h.cmdWall(session, strings.Join(cmd.Args, " "))
+		}
+	case "shutdown":
+		h.cmdShutdown(session, strings.Join(cmd.Args, " "))
+	case "delchannel":
+		if len(cmd.Args) > 0 {
+			h.cmdDeleteChannel(session, cmd.Args[0])
+		}
+	case "kill":
+		if len(cmd.Args) > 0 {
+			h.cmdKill(session, cmd.Args[0], strings.Join(cmd.Args[1:], " "))
+		}
+	case "gban":
+		if len(cmd.Args) > 0 {
+			h.cmdGlobalBan(session, cmd.Args)
+		}
+	case "ungban":
+		if len(cmd.Args) > 0 {
+			h.cmdGlobalUnban(session, cmd.Args[0])
+		}
+	case "gbans":
+		h.cmdGlobalBans(session)
+	case "invitecode":
+		h.cmdInviteCode(session)
+	case "set":
+		h.cmdSet(session, cmd.Args)
 	case "quit", "q", "q!":
-		h.cmdQuit(session)
+		h.cmdQuit(session, strings.Join(cmd.Args, " "))
 	default:
-		h.sendToSession(session, NewMessage(
-			MessageTypeError,
-			"",
-			"system",
-			"System",
-			fmt.Sprintf("Unknown command: /%s", cmd.Name),
-		))
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown command: /%s", cmd.Name)))
 	}
 }
 
+const adminHelp = `
+
+Server admins:
+/wall <msg> - Message everyone on the server
+/shutdown [reason] - Stop the server
+/delchannel <channel> - Delete any channel
+/kill <user> [reason] - Disconnect a user
+/gban <user|fingerprint> [duration] [reason] - Ban from the server
+/ungban <user|fingerprint> - Lift a server ban
+/gbans - List server bans
+/invitecode - Create a one-time code that enrolls a new SSH key`
+
 func (h *Hub) cmdHelp(session *Session) {
 	help := `Available commands:
 /help - Show this help
-/join <channel> - Join a channel
+/join <channel> [key] - Join a channel, or switch to one you are in
+/part [channel] - Leave a channel
 /list - List channels
+/create <channel> [topic] - Create a channel you own
+/topic [text|-] - Show, set or clear the channel topic
+/info [channel] - Show a channel's topic, description, modes and creator
+/invite <user> [channel] - Invite a user to a channel
 /users - List users in current channel
 /dm <user> <msg> - Send direct message
-/quit - Exit`
-
-	h.sendToSession(session, NewMessage(
-		MessageTypeSystem,
-		"",
-		"system",
-		"System",
-		help,
-	))
+/query <user> [msg] - Open a direct conversation
+/reply <id|last> <text> - Reply in a message's thread
+/thread <id|last> - Open a message's thread
+/react <id|last> <emoji|:shortcode:> - Toggle a reaction, e.g. :+1: or :tada:
+/edit <id|last> <text> - Change one of your messages
+/delete <id|last> - Delete a message
+/search <words> [in:#chan] [from:user] [before:/after:YYYY-MM-DD] - Search history
+/mentions [count] - List recent messages that mention you
+/mail [list|read [n]|clear] - Messages that arrived while you were away
+/away [message] - Mark yourself away, also after you leave; direct messages get your message as a reply
+/back - Clear your away status
+/register - Bind your nickname to your SSH key
+/nick <name> - Change your nickname
+/whois <user> - Show who is behind a nickname
+/set [joins show|collapse|hide] - Show or change your settings
+/quit [message] - Exit
+
+Keys in Normal mode (Esc):
+b - Toggle the channel sidebar
+u - Toggle the member list
+tab - Focus the sidebar, then j/k to move and Enter to switch
+Esc - Close search results or the open thread, or clear the selection
+j/k, gg/G - Select messages; older history loads at the top. Actions use the latest message without one
+y / Y - Copy the message text / ID to your clipboard
+r - Reply in the message's thread
++ / a - React with :+1: / pick a reaction
+e / dd - Edit / delete your message
+In search results, j/k pick a result and Enter jumps to it
+
+Channel operators:
+/kick <user> [reason] - Remove a user from the channel
+/ban <user|fingerprint> [duration] [reason] - Ban a user, e.g. 2h or 7d
+/unban <user|fingerprint> - Lift a ban
+/bans - List active bans
+/mute <user> [duration] - Silence a user
+/unmute <user> - Let a muted user speak again
+/op, /deop, /voice, /devoice <user> - Change a user's role
+/mode [+|-flags] [key] - Show or change modes: i invite-only, m moderated,
+  s secret, k key, t topic lock, e.g. /mode +mt or /mode +k hunter2.
+  Invite-only and keyed channels are private: hidden, and readable by members only
+/description <text> - Describe the channel
+/uninvite <user> - Take back an invite
+
+Channel owners:
+/archive [channel] - Make a channel read-only and hide it from /list
+/unarchive [channel] - Open an archived channel again
+/delete #<channel> - Delete a channel`
+	if h.IsAdmin(session.UserID) {
+		help += adminHelp
+	}
+
+	h.sendToSession(session, systemMessage(help))
 }
 
-func (h *Hub) cmdJoin(session *Session, channel string) {
-	h.joinChannel(session, channel)
+func (h *Hub) cmdJoin(session *Session, channel, key string) {
+	h.joinChannel(session, channel, key)
 }
 
 func (h *Hub) cmdListChannels(session *Session) {
@@ -67,17 +270,14 @@ func (h *Hub) cmdListChannels(session *Session) {
 
 	var list []string
 	for name, channel := range h.channels {
+		if !h.visible(channel, session.UserID) || channel.Archived() {
+			continue
+		}
 		list = append(list, fmt.Sprintf("#%s (%d users) - %s",
-			name, channel.UserCount(), channel.Topic))
+			name, channel.UserCount(), channel.Topic()))
 	}
 
-	h.sendToSession(session, NewMessage(
-		MessageTypeSystem,
-		"",
-		"system",
-		"System",
-		"Channels:\n"+strings.Join(list, "\n"),
-	))
+	h.sendToSession(session, systemMessage("Channels:\n"+strings.Join(list, "\n")))
 }
 
 func (h *Hub) cmdListUsers(session *Session) {
@@ -93,56 +293,101 @@ func (h *Hub) cmdListUsers(session *Session) {
 		return
 	}
 
-	channel.mu.RLock()
 	var users []string
-	for _, s := range channel.sessions {
-		users = append(users, s.Username)
+	for _, u := range channel.Members() {
+		users = append(users, channel.Role(u.ID).Prefix()+u.Username()+h.presenceSuffix(u.ID))
 	}
-	channel.mu.RUnlock()
-
-	h.sendToSession(session, NewMessage(
-		MessageTypeSystem,
-		"",
-		"system",
-		"System",
-		fmt.Sprintf("Users in #%s:\n%s", channel.Name, strings.Join(users, ", ")),
-	))
+
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Users in #%s:\n%s", channel.Name, strings.Join(users, ", "))))
 }
 
-func (h *Hub) cmdDirectMessage(session *Session, recipient, message string) {
-	h.mu.RLock()
-	var targetSession *Session
-	for _, s := range h.sessions {
-		if s.Username == recipient {
-			targetSession = s
-			break
-		}
+func (h *Hub) cmdRegister(session *Session) {
+	if !session.HasKey {
+		h.sendToSession(session, errorMessage("Connect with an SSH key to register a nickname"))
+		return
 	}
-	h.mu.RUnlock()
 
-	if targetSession == nil {
-		h.sendToSession(session, NewMessage(
-			MessageTypeError,
-			"",
-			"system",
-			"System",
-			fmt.Sprintf("User %s not found", recipient),
-		))
+	nick := session.Username()
+	err := h.registry.Register(nick, session.UserID)
+	switch {
+	case errors.Is(err, ErrNickTaken), errors.Is(err, ErrNickInvalid):
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot register %s: %v", nick, err)))
+	case err != nil:
+		log.Error("Failed to save registry", "err", err)
+		h.sendToSession(session, errorMessage("Registration failed, try again later"))
+	default:
+		h.sendToSession(session, systemMessage(fmt.Sprintf(
+			"%s is now registered to %s", nick, session.UserID)))
+	}
+}
+
+func (h *Hub) cmdNick(session *Session, nick string) {
+	if !ValidNick(nick) {
+		h.sendToSession(session, errorMessage(ErrNickInvalid.Error()))
 		return
 	}
 
-	dm := NewMessage(
-		MessageTypePrivate,
-		"",
-		session.UserID,
-		session.Username,
-		message,
-	)
+	h.mu.Lock()
+	if !h.nickAvailable(nick, session.UserID) {
+		h.mu.Unlock()
+		h.sendToSession(session, errorMessage(fmt.Sprintf("The nickname %s is taken", nick)))
+		return
+	}
+	user := session.User()
+	old := user.Username()
+	user.SetUsername(nick)
+	h.mu.Unlock()
+
+	for _, name := range user.Channels() {
+		if channel := h.findChannel(name); channel != nil {
+			h.announceEvent(channel, MessageTypeNick, user, fmt.Sprintf("%s is now known as %s", old, nick))
+			channel.Refresh()
+		}
+	}
+
+	h.sendToSession(session, systemMessage(fmt.Sprintf("You are now known as %s", nick)))
+}
+
+func (h *Hub) cmdWhois(session *Session, nick string) {
+	var lines []string
+
+	if target := h.findUserByNick(nick); target != nil {
+		lines = append(lines,
+			fmt.Sprintf("%s is online with %d connection(s)", target.Username(), target.ConnectionCount()),
+			fmt.Sprintf("Key: %s", target.ID),
+		)
+		if p, status := h.Presence(target.ID); p != PresenceOnline {
+			lines = append(lines, "Status: "+status)
+		}
+		// Only list channels the caller could find in /list themselves.
+		var channels []string
+		for _, name := range target.Channels() {
+			if channel := h.findChannel(name); channel != nil && h.visible(channel, session.UserID) {
+				channels = append(channels, name)
+			}
+		}
+		if len(channels) > 0 {
+			lines = append(lines, "Channels: #"+strings.Join(channels, " #"))
+		}
+	} else {
+		lines = append(lines, fmt.Sprintf("%s is not online", nick))
+		if reg, ok := h.registry.Lookup(nick); ok {
+			if seen, ok := h.lastSeenAt(reg.Fingerprint); ok {
+				lines = append(lines, "Last seen "+formatSeen(seen))
+			}
+		}
+	}
+
+	if reg, ok := h.registry.Lookup(nick); ok {
+		lines = append(lines, fmt.Sprintf("Registered to %s since %s",
+			reg.Fingerprint, reg.RegisteredAt.Format("2006-01-02")))
+	} else {
+		lines = append(lines, "Not registered")
+	}
 
-	h.sendToSession(session, dm)
-	h.sendToSession(targetSession, dm)
+	h.sendToSession(session, systemMessage(strings.Join(lines, "\n")))
 }
 
-func (h *Hub) cmdQuit(session *Session) {
-	session.Close()
+func (h *Hub) cmdQuit(session *Session, reason string) {
+	session.Quit(reason)
 }
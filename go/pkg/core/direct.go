@@ -0,0 +1,270 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const directPrefix = "dm:"
+
+// Conversation is a private conversation between two users. Its messages are
+// stored like channel messages, under the conversation's ID.
+type Conversation struct {
+	ID    string
+	users [2]string
+	names map[string]string
+	mu    sync.RWMutex
+}
+
+// DirectChat describes one of a user's conversations for display.
+type DirectChat struct {
+	ID       string
+	PeerID   string
+	Peer     string
+	Online   bool
+	Presence Presence
+	Status   string
+}
+
+func directID(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return directPrefix + a + "|" + b
+}
+
+// IsDirect reports whether channelID names a direct conversation rather than
+// a channel.
+func IsDirect(channelID string) bool {
+	return strings.HasPrefix(channelID, directPrefix)
+}
+
+func newConversation(a, b string) *Conversation {
+	if b < a {
+		a, b = b, a
+	}
+	return &Conversation{
+		ID:    directID(a, b),
+		users: [2]string{a, b},
+		names: make(map[string]string),
+	}
+}
+
+func (c *Conversation) Includes(userID string) bool {
+	return c.users[0] == userID || c.users[1] == userID
+}
+
+// Peer returns the other participant. A conversation with yourself is its
+// own peer.
+func (c *Conversation) Peer(userID string) string {
+	if c.users[0] == userID {
+		return c.users[1]
+	}
+	return c.users[0]
+}
+
+func (c *Conversation) setName(userID, nick string) {
+	c.mu.Lock()
+	c.names[userID] = nick
+	c.mu.Unlock()
+}
+
+// Name is the last nickname userID was seen using in the conversation.
+func (c *Conversation) Name(userID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.names[userID]
+}
+
+// loadConversations rebuilds the conversation list from stored messages.
+func (h *Hub) loadConversations() {
+	err := h.store.RangeTime(time.Time{}, time.Time{}, func(msg *Message) bool {
+		if !IsDirect(msg.ChannelID) {
+			return true
+		}
+		conv, ok := h.conversations[msg.ChannelID]
+		if !ok {
+			ids := strings.SplitN(strings.TrimPrefix(msg.ChannelID, directPrefix), "|", 2)
+			if len(ids) != 2 {
+				return true
+			}
+			conv = newConversation(ids[0], ids[1])
+			h.conversations[conv.ID] = conv
+		}
+		conv.setName(msg.UserID, msg.Username)
+		return true
+	})
+	if err != nil {
+		log.Error("Failed to load conversations", "err", err)
+	}
+}
+
+// conversation returns the conversation between two users, creating it if
+// needed.
+func (h *Hub) conversation(a, b string) *Conversation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := directID(a, b)
+	conv, ok := h.conversations[id]
+	if !ok {
+		conv = newConversation(a, b)
+		h.conversations[id] = conv
+	}
+	return conv
+}
+
+func (h *Hub) findConversation(id string) *Conversation {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.conversations[id]
+}
+
+func (h *Hub) conversationsFor(userID string) []*Conversation {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var convs []*Conversation
+	for _, conv := range h.conversations {
+		if conv.Includes(userID) {
+			convs = append(convs, conv)
+		}
+	}
+	return convs
+}
+
+// DirectChats lists a user's conversations ordered by the peer's nickname.
+func (h *Hub) DirectChats(userID string) []DirectChat {
+	var chats []DirectChat
+	for _, conv := range h.conversationsFor(userID) {
+		peerID := conv.Peer(userID)
+		chat := DirectChat{ID: conv.ID, PeerID: peerID, Peer: conv.Name(peerID)}
+		if peer := h.findUser(peerID); peer != nil {
+			chat.Peer = peer.Username()
+			chat.Online = peer.Online()
+		}
+		chat.Presence, chat.Status = h.Presence(peerID)
+		if chat.Peer == "" {
+			chat.Peer = "guest-" + shortFingerprint(peerID)
+		}
+		chats = append(chats, chat)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		return strings.ToLower(chats[i].Peer) < strings.ToLower(chats[j].Peer)
+	})
+	return chats
+}
+
+// resolvePeer finds who nick refers to for a direct message: someone online,
+// a registered nickname, or someone the user has talked to before.
+func (h *Hub) resolvePeer(userID, nick string) (string, string, bool) {
+	if u := h.findUserByNick(nick); u != nil {
+		return u.ID, u.Username(), true
+	}
+	if reg, ok := h.registry.Lookup(nick); ok {
+		return reg.Fingerprint, reg.Nick, true
+	}
+	for _, chat := range h.DirectChats(userID) {
+		if strings.EqualFold(chat.Peer, nick) {
+			return chat.PeerID, chat.Peer, true
+		}
+	}
+	return "", "", false
+}
+
+// openConversation looks up or starts the conversation between session's
+// user and nick.
+func (h *Hub) openConversation(session *Session, nick string) *Conversation {
+	peerID, peerName, ok := h.resolvePeer(session.UserID, nick)
+	if !ok {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("User %s not found", nick)))
+		return nil
+	}
+
+	conv := h.conversation(session.UserID, peerID)
+	conv.setName(peerID, peerName)
+	return conv
+}
+
+func (h *Hub) sendDirect(session *Session, conv *Conversation, text string) {
+	h.postDirect(conv, NewMessage(MessageTypePrivate, conv.ID, session.UserID, session.Username(), text))
+	h.replyAwayDirect(session, conv)
+}
+
+func (h *Hub) postDirect(conv *Conversation, msg *Message) {
+	conv.setName(msg.UserID, msg.Username)
+
+	if err := h.store.Append(msg); err != nil {
+		log.Error("Failed to store message", "conversation", conv.ID, "err", err)
+	}
+
+	h.deliverDirect(conv, msg)
+	h.queueMail(conv.Peer(msg.UserID), msg)
+}
+
+// deliverDirect sends msg to whichever participants are connected.
+func (h *Hub) deliverDirect(conv *Conversation, msg *Message) {
+	if u := h.findUser(conv.users[0]); u != nil {
+		u.Deliver(msg)
+	}
+	if conv.users[1] != conv.users[0] {
+		if u := h.findUser(conv.users[1]); u != nil {
+			u.Deliver(msg)
+		}
+	}
+}
+
+// replayConversations sends a new connection the recent history of every
+// conversation its user is part of.
+func (h *Hub) replayConversations(session *Session) {
+	for _, conv := range h.conversationsFor(session.UserID) {
+		history, err := h.store.RangeChannel(conv.ID, time.Time{}, h.historyReplay)
+		if err != nil {
+			log.Error("Failed to load history", "conversation", conv.ID, "err", err)
+			continue
+		}
+		for _, msg := range history {
+			h.sendToSession(session, replayed(msg))
+		}
+	}
+}
+
+func (h *Hub) cmdDirectMessage(session *Session, recipient, message string) {
+	if conv := h.openConversation(session, recipient); conv != nil {
+		h.sendDirect(session, conv, message)
+	}
+}
+
+// cmdQuery switches the connection to the conversation with nick, sending
+// text into it if given.
+func (h *Hub) cmdQuery(session *Session, nick, text string) {
+	conv := h.openConversation(session, nick)
+	if conv == nil {
+		return
+	}
+
+	session.CurrentChannel = conv.ID
+	h.sendToSession(session, refreshMessage())
+	if text != "" {
+		h.sendDirect(session, conv, text)
+	}
+}
+
+// closeConversation moves a connection that is looking at a conversation back
+// to the user's most recently joined channel.
+func (h *Hub) closeConversation(session *Session, id string) {
+	if session.CurrentChannel != id {
+		return
+	}
+
+	session.CurrentChannel = ""
+	if channels := session.User().Channels(); len(channels) > 0 {
+		session.CurrentChannel = channels[len(channels)-1]
+	}
+	h.sendToSession(session, refreshMessage())
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// findPage is how many messages findRecent reads at a time.
+const findPage = 100
+
+// findMessage resolves ref, a message ID prefix or "last", among the messages
+// of the session's current channel or conversation. "last" is the user's own
+// most recent message.
+func (h *Hub) findMessage(session *Session, ref string) (*Message, error) {
+	channelID := session.CurrentChannel
+	if channelID == "" {
+		return nil, fmt.Errorf("you are not in a channel")
+	}
+
+	ref = strings.ToLower(ref)
+	var msg *Message
+	var err error
+	switch {
+	case ref == "last":
+		msg, err = h.findRecent(channelID, func(m *Message) bool { return m.UserID == session.UserID })
+	case len(ref) >= shortIDLength:
+		// Full and short IDs are indexed; anything in between is checked
+		// against the message its short ID points at.
+		msg, err = h.store.Lookup(channelID, ref)
+		if errors.Is(err, ErrMessageNotFound) {
+			msg, err = h.store.Lookup(channelID, ref[:shortIDLength])
+		}
+		if err == nil && (!strings.HasPrefix(msg.ID, ref) || msg.Deleted) {
+			msg, err = nil, ErrMessageNotFound
+		}
+	case len(ref) >= 4:
+		msg, err = h.findRecent(channelID, func(m *Message) bool { return strings.HasPrefix(m.ID, ref) })
+	default:
+		err = ErrMessageNotFound
+	}
+
+	if errors.Is(err, ErrMessageNotFound) {
+		return nil, fmt.Errorf("no message %s here", ref)
+	}
+	return msg, err
+}
+
+// findRecent returns the newest message of channelID, that is not deleted,
+// for which match is true. It reads the history a page at a time from the
+// end, as what it looks for is usually recent.
+func (h *Hub) findRecent(channelID string, match func(*Message) bool) (*Message, error) {
+	before := time.Time{}
+	for {
+		page, err := h.store.RangeChannel(channelID, before, findPage)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(page) - 1; i >= 0; i-- {
+			if msg := page[i]; !msg.Deleted && match(msg) {
+				return msg, nil
+			}
+		}
+		if len(page) < findPage {
+			return nil, ErrMessageNotFound
+		}
+		before = page[0].Timestamp
+	}
+}
+
+// deliver sends msg to everyone who can see channelID without storing it.
+func (h *Hub) deliver(channelID string, msg *Message) {
+	if IsDirect(channelID) {
+		if conv := h.findConversation(channelID); conv != nil {
+			h.deliverDirect(conv, msg)
+		}
+		return
+	}
+	if channel := h.findChannel(channelID); channel != nil {
+		channel.Send(msg)
+	}
+}
+
+func (h *Hub) cmdEdit(session *Session, ref, text string) {
+	// Holding updateMu keeps a concurrent reply count or reaction from being
+	// lost.
+	h.updateMu.Lock()
+	defer h.updateMu.Unlock()
+
+	orig, err := h.findMessage(session, ref)
+	if err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot edit: %v", err)))
+		return
+	}
+	if orig.UserID != session.UserID {
+		h.sendToSession(session, errorMessage("You can only edit your own messages"))
+		return
+	}
+	if h.archived(orig.ChannelID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot edit: %v", ErrArchived)))
+		return
+	}
+
+	edited := *orig
+	edited.Text = text
+	edited.EditedAt = time.Now()
+	if !IsDirect(edited.ChannelID) {
+		edited.Mentions = h.resolveMentions(text)
+	}
+	if err := h.store.Update(&edited); err != nil {
+		log.Error("Failed to store edit", "message", orig.ID, "err", err)
+		h.sendToSession(session, errorMessage("Cannot edit: the message could not be saved"))
+		return
+	}
+
+	event := NewMessage(MessageTypeEdit, edited.ChannelID, session.UserID, session.Username(), text)
+	event.Ref = edited.ID
+	event.EditedAt = edited.EditedAt
+	event.Mentions = edited.Mentions
+	h.deliver(edited.ChannelID, event)
+}
+
+// cmdDelete removes a message. Authors can delete their own messages and
+// channel operators anyone's in their channel.
+func (h *Hub) cmdDelete(session *Session, ref string) {
+	h.updateMu.Lock()
+	defer h.updateMu.Unlock()
+
+	orig, err := h.findMessage(session, ref)
+	if err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot delete: %v", err)))
+		return
+	}
+	if h.archived(orig.ChannelID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot delete: %v", ErrArchived)))
+		return
+	}
+	if orig.UserID != session.UserID {
+		if IsDirect(orig.ChannelID) {
+			h.sendToSession(session, errorMessage("You can only delete your own messages"))
+			return
+		}
+		if h.moderate(session, RoleOperator) == nil {
+			return
+		}
+	}
+
+	deleted := *orig
+	deleted.Text = ""
+	deleted.Mentions = nil
+	deleted.Reactions = nil
+	deleted.Deleted = true
+	if err := h.store.Update(&deleted); err != nil {
+		log.Error("Failed to store deletion", "message", orig.ID, "err", err)
+		h.sendToSession(session, errorMessage("Cannot delete: the message could not be saved"))
+		return
+	}
+
+	event := NewMessage(MessageTypeDelete, deleted.ChannelID, session.UserID, session.Username(), "")
+	event.Ref = deleted.ID
+	h.deliver(deleted.ChannelID, event)
+}
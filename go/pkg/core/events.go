@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// JoinDisplay is how a user wants join, part, quit and nick change events
+// shown.
+type JoinDisplay string
+
+const (
+	JoinsShow JoinDisplay = "show"
+	// JoinsCollapse folds runs of events into a single line.
+	JoinsCollapse JoinDisplay = "collapse"
+	JoinsHide     JoinDisplay = "hide"
+)
+
+// QuitTimeout is the quit reason of connections that stopped answering.
+const QuitTimeout = "Connection timed out"
+
+// Preferences are per-user settings changed with /set.
+type Preferences struct {
+	Joins JoinDisplay `json:"joins,omitempty"`
+}
+
+// WithPreferences sets where per-user settings are kept.
+func WithPreferences(path string) HubOption {
+	return func(h *Hub) {
+		h.prefsPath = path
+	}
+}
+
+// Preferences returns userID's settings with defaults filled in.
+func (h *Hub) Preferences(userID string) Preferences {
+	h.prefsMu.RLock()
+	prefs := h.prefs[userID]
+	h.prefsMu.RUnlock()
+
+	if prefs.Joins == "" {
+		prefs.Joins = JoinsShow
+	}
+	return prefs
+}
+
+// IsMembershipEvent reports whether msg is a join, part, quit or nick change.
+func IsMembershipEvent(msg *Message) bool {
+	switch msg.Type {
+	case MessageTypeJoin, MessageTypeLeave, MessageTypeNick:
+		return true
+	}
+	return false
+}
+
+// announceEvent tells the members of channel what user did, skipping
+// members who hide such events. Events are not stored.
+func (h *Hub) announceEvent(channel *Channel, msgType MessageType, user *User, text string) {
+	msg := NewMessage(msgType, channel.Name, user.ID, user.Username(), text)
+	for _, member := range channel.Members() {
+		if msgType == MessageTypeNick && member.ID == user.ID {
+			continue
+		}
+		if h.Preferences(member.ID).Joins == JoinsHide {
+			continue
+		}
+		member.Deliver(msg)
+	}
+}
+
+// quitMessage describes a user's last connection going away, e.g.
+// "alice quit: Connection timed out".
+func quitMessage(nick, reason string) string {
+	return nick + " quit" + formatReason(reason)
+}
+
+func (h *Hub) cmdSet(session *Session, args []string) {
+	if len(args) == 0 {
+		prefs := h.Preferences(session.UserID)
+		h.sendToSession(session, systemMessage(fmt.Sprintf(
+			"Settings:\njoins %s - Join, part and quit events: show, collapse or hide", prefs.Joins)))
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "joins":
+		if len(args) < 2 {
+			h.sendToSession(session, errorMessage("Usage: /set joins show|collapse|hide"))
+			return
+		}
+		display := JoinDisplay(strings.ToLower(args[1]))
+		switch display {
+		case JoinsShow, JoinsCollapse, JoinsHide:
+		default:
+			h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown value %q, use show, collapse or hide", args[1])))
+			return
+		}
+
+		h.prefsMu.Lock()
+		prefs := h.prefs[session.UserID]
+		prefs.Joins = display
+		h.prefs[session.UserID] = prefs
+		h.prefsMu.Unlock()
+		h.savePreferences()
+
+		h.sendToSession(session, systemMessage(fmt.Sprintf("Join and quit events: %s", display)))
+		session.User().Refresh()
+	default:
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown setting %q, see /set", args[0])))
+	}
+}
+
+func (h *Hub) loadPreferences() {
+	if h.prefsPath == "" {
+		return
+	}
+	if err := readJSONFile(h.prefsPath, &h.prefs); err != nil {
+		log.Error("Failed to load preferences", "path", h.prefsPath, "err", err)
+	}
+	if h.prefs == nil {
+		h.prefs = make(map[string]Preferences)
+	}
+}
+
+func (h *Hub) savePreferences() {
+	if h.prefsPath == "" {
+		return
+	}
+
+	h.prefsMu.RLock()
+	defer h.prefsMu.RUnlock()
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+	if err := writeJSONFile(h.prefsPath, h.prefs); err != nil {
+		log.Error("Failed to save preferences", "path", h.prefsPath, "err", err)
+	}
+}
@@ -2,6 +2,9 @@ package core
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,7 +13,44 @@ import (
 
 type Hub struct {
 	sessions map[string]*Session
+	users    map[string]*User
 	channels map[string]*Channel
+	store    Store
+	index    *Index
+
+	conversations map[string]*Conversation
+
+	registry *Registry
+	invites  *Invites
+	mailbox  *Mailbox
+
+	admins     map[string]bool
+	globalBans map[string]*Ban
+
+	defaultChannels []defaultChannel
+	motd            string
+	historyReplay   int
+	createPolicy    CreatePolicy
+	channelExpiry   time.Duration
+	idleAfter       time.Duration
+
+	lastSeen     map[string]*seenRecord
+	lastSeenPath string
+
+	prefs     map[string]Preferences
+	prefsPath string
+	prefsMu   sync.RWMutex
+
+	channelsPath string
+	bansPath     string
+	saveMu       sync.Mutex
+
+	// updateMu serializes read-modify-write updates of stored messages,
+	// such as reply counts and reactions.
+	updateMu sync.Mutex
+
+	shutdownRequest chan struct{}
+	shutdownOnce    sync.Once
 
 	register   chan *Session
 	unregister chan string
@@ -21,31 +61,156 @@ type Hub struct {
 	cancel context.CancelFunc
 }
 
-func NewHub() *Hub {
+type HubOption func(*Hub)
+
+type defaultChannel struct {
+	name  string
+	topic string
+	owner string
+}
+
+func WithStore(store Store) HubOption {
+	return func(h *Hub) {
+		h.store = store
+	}
+}
+
+func WithRegistry(registry *Registry) HubOption {
+	return func(h *Hub) {
+		h.registry = registry
+	}
+}
+
+func WithInvites(invites *Invites) HubOption {
+	return func(h *Hub) {
+		h.invites = invites
+	}
+}
+
+func WithMailbox(mailbox *Mailbox) HubOption {
+	return func(h *Hub) {
+		h.mailbox = mailbox
+	}
+}
+
+func WithChannelState(path string) HubOption {
+	return func(h *Hub) {
+		h.channelsPath = path
+	}
+}
+
+func WithAdmins(fingerprints []string) HubOption {
+	return func(h *Hub) {
+		for _, fp := range fingerprints {
+			h.admins[fp] = true
+		}
+	}
+}
+
+func WithGlobalBans(path string) HubOption {
+	return func(h *Hub) {
+		h.bansPath = path
+	}
+}
+
+// WithDefaultChannel adds a channel that always exists. The first default
+// channel is where new connections land. owner is the key fingerprint of the
+// channel's owner, or empty to leave it to server admins, who are operators of
+// every default channel.
+func WithDefaultChannel(name, topic, owner string) HubOption {
+	return func(h *Hub) {
+		h.defaultChannels = append(h.defaultChannels, defaultChannel{name: name, topic: topic, owner: owner})
+	}
+}
+
+func WithMOTD(motd string) HubOption {
+	return func(h *Hub) {
+		h.motd = motd
+	}
+}
+
+// WithHistoryReplay sets how many messages are replayed on join.
+func WithHistoryReplay(n int) HubOption {
+	return func(h *Hub) {
+		h.historyReplay = n
+	}
+}
+
+func NewHub(opts ...HubOption) *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	h := &Hub{
-		sessions:   make(map[string]*Session),
-		channels:   make(map[string]*Channel),
-		register:   make(chan *Session, 16),
-		unregister: make(chan string, 16),
-		ctx:        ctx,
-		cancel:     cancel,
+		sessions:      make(map[string]*Session),
+		users:         make(map[string]*User),
+		channels:      make(map[string]*Channel),
+		conversations: make(map[string]*Conversation),
+		admins:        make(map[string]bool),
+		globalBans:    make(map[string]*Ban),
+		register:      make(chan *Session, 16),
+		unregister:    make(chan string, 16),
+		ctx:           ctx,
+		cancel:        cancel,
+
+		historyReplay:   20,
+		createPolicy:    CreateOnJoin,
+		idleAfter:       10 * time.Minute,
+		lastSeen:        make(map[string]*seenRecord),
+		prefs:           make(map[string]Preferences),
+		shutdownRequest: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.store == nil {
+		h.store = NewMemoryStore(100)
+	}
+	if h.registry == nil {
+		h.registry, _ = OpenRegistry("")
 	}
+	if h.mailbox == nil {
+		h.mailbox, _ = OpenMailbox("")
+	}
+	h.buildIndex()
 
-	h.createChannel("general", "General discussion")
-	h.createChannel("random", "Random")
+	if len(h.defaultChannels) == 0 {
+		h.defaultChannels = []defaultChannel{
+			{name: "general", topic: "General discussion"},
+			{name: "random", topic: "Random"},
+		}
+	}
+
+	h.loadChannels()
+	h.loadGlobalBans()
+	h.loadConversations()
+	h.loadLastSeen()
+	h.loadPreferences()
+	for _, dc := range h.defaultChannels {
+		h.staffChannel(h.ensureChannel(dc.name, dc.topic), dc.owner)
+	}
 
 	return h
 }
 
 func (h *Hub) Run() {
 	defer h.cleanup()
+
+	expire, stop := h.expiryTicks()
+	defer stop()
+	presence := time.NewTicker(presenceInterval)
+	defer presence.Stop()
+
 	for {
 		select {
 		case <-h.ctx.Done():
 			return
 
+		case <-expire:
+			h.expireChannels()
+
+		case <-presence.C:
+			h.sweepPresence()
+
 		case session := <-h.register:
 			h.handleRegister(session)
 			go h.handleSession(session)
@@ -67,7 +232,11 @@ func (h *Hub) handleSession(session *Session) {
 			return
 
 		case msg := <-session.inboundMessages():
-			h.broadcastToChannel(msg)
+			if msg.Type == MessageTypeTyping {
+				h.relayTyping(session, msg)
+			} else {
+				h.broadcastToChannel(session, msg)
+			}
 
 		case cmd := <-session.commands:
 			h.executeCommand(session, cmd)
@@ -87,16 +256,60 @@ func (h *Hub) handleRegister(session *Session) {
 	}
 
 	if _, exists := h.sessions[session.ID]; exists {
-		log.Warn("Session %s already registered", session.ID)
+		log.Warn("Session already registered", "session", session.ID)
 		return
 	}
+	if ban, ok := h.globalBans[session.UserID]; ok && ban.Active(time.Now()) {
+		log.Info("Refused banned session", "fingerprint", session.UserID)
+		session.EnqueueOutbound(errorMessage("You are banned from this server" + formatReason(ban.Reason)))
+		session.Quit("Banned")
+		// The session never joins h.sessions, so nothing else ends its
+		// client.
+		close(session.outbox)
+		return
+	}
+
+	if h.motd != "" {
+		h.sendToSession(session, systemMessage(h.motd))
+	}
+
+	active := ""
 
+	user, exists := h.users[session.UserID]
+	if exists {
+		for _, sibling := range user.Sessions() {
+			if sibling.CurrentChannel != "" {
+				active = sibling.CurrentChannel
+				break
+			}
+		}
+	} else {
+		user = NewUser(session.UserID, session.requestedName, session.HasKey)
+		if !h.nickAvailable(user.Username(), user.ID) {
+			user.SetUsername(h.fallbackNick(user.ID))
+			h.sendToSession(session, systemMessage(fmt.Sprintf(
+				"The nickname %s is taken, you are now known as %s", session.requestedName, user.Username())))
+		}
+		h.users[user.ID] = user
+		if seen, ok := h.lastSeen[user.ID]; ok && seen.Away {
+			user.setAway(seen.AwayMessage)
+			h.sendToSession(session, systemMessage("You are still away, type /back when you are back"))
+		}
+		h.deliverMail(session)
+	}
+
+	user.AddSession(session)
 	h.sessions[session.ID] = session
 
 	go func() {
 		_, cancel := context.WithTimeout(h.ctx, 1*time.Second)
 		defer cancel()
-		h.joinChannel(session, "general")
+		h.replayConversations(session)
+		if exists {
+			h.resumeChannels(session, active)
+		} else {
+			h.joinChannel(session, h.landingChannel(), "")
+		}
 	}()
 }
 
@@ -108,11 +321,23 @@ func (h *Hub) handleUnregister(sessionID string) {
 		return
 	}
 
-	for _, channel := range h.channels {
-		channel.RemoveSession(sessionID)
-	}
-
 	delete(h.sessions, sessionID)
+
+	if user := session.User(); user != nil && user.RemoveSession(sessionID) == 0 {
+		quit := quitMessage(user.Username(), session.QuitReason())
+		for _, name := range user.Channels() {
+			if channel, ok := h.channels[name]; ok {
+				channel.RemoveMember(user.ID)
+				h.announceEvent(channel, MessageTypeLeave, user, quit)
+				channel.Refresh()
+			}
+		}
+		delete(h.users, user.ID)
+		seen := &seenRecord{At: time.Now()}
+		seen.AwayMessage, seen.Away = user.Away()
+		h.lastSeen[user.ID] = seen
+		h.saveLastSeenLocked()
+	}
 	h.mu.Unlock()
 
 	go func() {
@@ -133,7 +358,20 @@ func (h *Hub) handleUnregister(sessionID string) {
 	}()
 }
 
-func (h *Hub) broadcastToChannel(msg *Message) {
+func (h *Hub) broadcastToChannel(session *Session, msg *Message) {
+	if IsDirect(msg.ChannelID) {
+		conv := h.findConversation(msg.ChannelID)
+		if conv == nil || !conv.Includes(session.UserID) {
+			return
+		}
+		msg.Type = MessageTypePrivate
+		if h.attachReply(session, msg) {
+			h.postDirect(conv, msg)
+			h.replyAwayDirect(session, conv)
+		}
+		return
+	}
+
 	h.mu.RLock()
 	channel, exists := h.channels[msg.ChannelID]
 	h.mu.RUnlock()
@@ -142,30 +380,221 @@ func (h *Hub) broadcastToChannel(msg *Message) {
 		return
 	}
 
-	channel.Broadcast(msg, h.sessions)
+	if !channel.HasMember(msg.UserID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("You are not in #%s", channel.Name)))
+		return
+	}
+	if err := channel.CheckSpeak(msg.UserID); err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot send to #%s: %v", channel.Name, err)))
+		return
+	}
+
+	if !h.attachReply(session, msg) {
+		return
+	}
+	msg.Mentions = h.resolveMentions(msg.Text)
+	channel.Broadcast(msg)
+	h.queueMentions(msg)
+}
+
+// relayTyping passes a typing event on to everyone else who can see the
+// channel or conversation. It is never stored.
+func (h *Hub) relayTyping(session *Session, msg *Message) {
+	var recipients []*User
+	if IsDirect(msg.ChannelID) {
+		conv := h.findConversation(msg.ChannelID)
+		if conv == nil || !conv.Includes(session.UserID) {
+			return
+		}
+		if peer := h.findUser(conv.Peer(session.UserID)); peer != nil {
+			recipients = append(recipients, peer)
+		}
+	} else {
+		channel := h.findChannel(msg.ChannelID)
+		if channel == nil || !channel.HasMember(session.UserID) || channel.CheckSpeak(session.UserID) != nil {
+			return
+		}
+		recipients = channel.Members()
+	}
+
+	for _, user := range recipients {
+		if user.ID != session.UserID {
+			user.Deliver(msg)
+		}
+	}
 }
 
-func (h *Hub) joinChannel(session *Session, channelName string) {
+// joinChannel joins channelName, creating it if needed. key unlocks channels
+// with +k set.
+func (h *Hub) joinChannel(session *Session, requested, key string) {
+	channelName := normalizeChannel(requested)
+	if !ValidChannel(channelName) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot join %s: not a valid channel name", requested)))
+		return
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	user := session.User()
+
+	if user.InChannel(channelName) {
+		session.CurrentChannel = channelName
+		h.sendToSession(session, refreshMessage())
+		return
+	}
+
 	channel, exists := h.channels[channelName]
 	if !exists {
+		if err := h.canCreate(user.ID, true); err != nil {
+			h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot join #%s: %v", channelName, err)))
+			return
+		}
+		if err := h.purgeHistory(channelName); err != nil {
+			h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot join #%s: %v", channelName, err)))
+			return
+		}
 		channel = h.createChannel(channelName, "")
+		channel.SetRole(user.ID, RoleOwner)
+		channel.setCreator(user.Username())
+		channel.ephemeral = true
+		h.saveChannelsLocked()
 	}
 
-	if session.CurrentChannel != "" && session.CurrentChannel != channelName {
-		if oldChannel, ok := h.channels[session.CurrentChannel]; ok {
-			oldChannel.RemoveSession(session.ID)
-		}
+	if err := h.checkJoin(channel, user.ID, key); err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot join #%s: %v", channelName, err)))
+		return
+	}
+	if channel.Modes().Key != "" && !channel.Allowed(user.ID) {
+		// Getting in with the key counts as an invite, so the user still
+		// belongs to the channel, and gets its mentions, while offline.
+		channel.invite(user.ID, user.Username())
+		h.saveChannelsLocked()
 	}
 
+	if err := channel.AddMember(user); err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot join #%s: %v", channelName, err)))
+		return
+	}
+	user.addChannel(channelName)
+
+	// Membership is per user, so every connection gets the channel's history
+	// but only the one that asked switches to it.
+	history := channel.GetRecentHistory(h.historyReplay)
+	for _, s := range user.Sessions() {
+		for _, msg := range history {
+			h.sendToSession(s, replayed(msg))
+		}
+	}
+	h.announceEvent(channel, MessageTypeJoin, user, fmt.Sprintf("%s joined #%s", user.Username(), channelName))
 	session.CurrentChannel = channelName
-	channel.AddSession(session)
+	channel.Refresh()
+}
+
+// resumeChannels brings a new connection of an already connected user up to
+// date with every channel the user is in.
+func (h *Hub) resumeChannels(session *Session, active string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	user := session.User()
+	for _, name := range user.Channels() {
+		if channel, ok := h.channels[name]; ok {
+			for _, msg := range channel.GetRecentHistory(h.historyReplay) {
+				h.sendToSession(session, replayed(msg))
+			}
+		}
+	}
+	session.CurrentChannel = active
+	h.sendToSession(session, refreshMessage())
+}
+
+func (h *Hub) partChannel(session *Session, channelName string) {
+	user := session.User()
+	if IsDirect(channelName) {
+		h.closeConversation(session, channelName)
+		return
+	}
+	channelName = normalizeChannel(channelName)
+
+	if !user.InChannel(channelName) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("You are not in #%s", channelName)))
+		return
+	}
+
+	h.leaveChannel(user, channelName)
+	if channel := h.findChannel(channelName); channel != nil {
+		channel.RemoveMember(user.ID)
+		h.announceEvent(channel, MessageTypeLeave, user, fmt.Sprintf("%s left #%s", user.Username(), channelName))
+		channel.Refresh()
+	}
+}
+
+// leaveChannel drops channelName from the user's channels and moves every
+// connection that was looking at it to another channel.
+func (h *Hub) leaveChannel(user *User, channelName string) {
+	user.removeChannel(channelName)
+
+	next := ""
+	if remaining := user.Channels(); len(remaining) > 0 {
+		next = remaining[len(remaining)-1]
+	}
+	for _, s := range user.Sessions() {
+		if s.CurrentChannel == channelName {
+			s.CurrentChannel = next
+		}
+	}
+	user.Refresh()
+}
+
+// normalizeChannel turns what a user typed into a channel name. Direct
+// conversation IDs are not channels and come out empty.
+func normalizeChannel(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	if IsDirect(name) {
+		return ""
+	}
+	return name
+}
+
+// nickAvailable reports whether userID may use nick. Callers must hold h.mu.
+func (h *Hub) nickAvailable(nick, userID string) bool {
+	if !h.registry.Allowed(nick, userID) {
+		return false
+	}
+	for _, u := range h.users {
+		if u.ID != userID && strings.EqualFold(u.Username(), nick) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Hub) fallbackNick(userID string) string {
+	base := "guest-" + shortFingerprint(userID)
+	nick := base
+	for i := 2; !h.nickAvailable(nick, userID); i++ {
+		nick = fmt.Sprintf("%s-%d", base, i)
+	}
+	return nick
+}
 
-	for _, msg := range channel.GetRecentHistory(20) {
-		h.sendToSession(session, msg)
+func (h *Hub) findUser(userID string) *User {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.users[userID]
+}
+
+func (h *Hub) findUserByNick(nick string) *User {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, u := range h.users {
+		if strings.EqualFold(u.Username(), nick) {
+			return u
+		}
 	}
+	return nil
 }
 
 func (h *Hub) sendToSession(session *Session, msg *Message) {
@@ -173,11 +602,128 @@ func (h *Hub) sendToSession(session *Session, msg *Message) {
 }
 
 func (h *Hub) createChannel(name, topic string) *Channel {
-	channel := NewChannel(name, topic)
+	channel := NewChannel(name, topic, h.store)
 	h.channels[name] = channel
 	return channel
 }
 
+func (h *Hub) landingChannel() string {
+	return h.defaultChannels[0].name
+}
+
+// staffChannel gives a default channel its configured owner and makes server
+// admins its operators, so it can be moderated without anyone claiming it.
+func (h *Hub) staffChannel(channel *Channel, owner string) {
+	if owner != "" {
+		channel.SetRole(owner, RoleOwner)
+	}
+	for fp := range h.admins {
+		if channel.Role(fp) < RoleOperator {
+			channel.SetRole(fp, RoleOperator)
+		}
+	}
+}
+
+func (h *Hub) ensureChannel(name, topic string) *Channel {
+	if channel, ok := h.channels[name]; ok {
+		return channel
+	}
+	return h.createChannel(name, topic)
+}
+
+// Member describes a channel member for display.
+type Member struct {
+	UserID   string
+	Username string
+	Role     Role
+	Online   bool
+	Presence Presence
+	Status   string
+}
+
+// ChannelMembers lists the members of a channel, highest role first.
+func (h *Hub) ChannelMembers(name string) []Member {
+	channel := h.findChannel(name)
+	if channel == nil {
+		return nil
+	}
+
+	users := channel.Members()
+	members := make([]Member, 0, len(users))
+	for _, u := range users {
+		presence, status := h.Presence(u.ID)
+		members = append(members, Member{
+			UserID:   u.ID,
+			Username: u.Username(),
+			Role:     channel.Role(u.ID),
+			Online:   u.Online(),
+			Presence: presence,
+			Status:   status,
+		})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Role != members[j].Role {
+			return members[i].Role > members[j].Role
+		}
+		return strings.ToLower(members[i].Username) < strings.ToLower(members[j].Username)
+	})
+	return members
+}
+
+func (h *Hub) findChannel(name string) *Channel {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.channels[name]
+}
+
+func (h *Hub) currentChannel(session *Session) *Channel {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.channels[session.CurrentChannel]
+}
+
+func (h *Hub) loadChannels() {
+	if h.channelsPath == "" {
+		return
+	}
+
+	var records []channelRecord
+	if err := readJSONFile(h.channelsPath, &records); err != nil {
+		log.Error("Failed to load channel state", "path", h.channelsPath, "err", err)
+		return
+	}
+	for _, rec := range records {
+		h.ensureChannel(rec.Name, rec.Topic).restore(rec)
+	}
+}
+
+func (h *Hub) saveChannels() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.saveChannelsLocked()
+}
+
+// saveChannelsLocked persists channel state. Callers must hold h.mu.
+func (h *Hub) saveChannelsLocked() {
+	if h.channelsPath == "" {
+		return
+	}
+
+	records := make([]channelRecord, 0, len(h.channels))
+	for _, channel := range h.channels {
+		records = append(records, channel.record())
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+	if err := writeJSONFile(h.channelsPath, records); err != nil {
+		log.Error("Failed to save channel state", "path", h.channelsPath, "err", err)
+	}
+}
+
 func (h *Hub) Shutdown() {
 	h.cancel()
 }
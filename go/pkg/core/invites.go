@@ -0,0 +1,102 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const inviteLifetime = 7 * 24 * time.Hour
+
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Invites holds one-time codes that let a new SSH key enroll itself on a
+// server that only admits allowlisted keys. An empty path keeps the codes
+// in memory only.
+type Invites struct {
+	path  string
+	codes map[string]*Invite
+	mu    sync.Mutex
+}
+
+func OpenInvites(path string) (*Invites, error) {
+	i := &Invites{
+		path:  path,
+		codes: make(map[string]*Invite),
+	}
+	if path == "" {
+		return i, nil
+	}
+
+	var invites []*Invite
+	if err := readJSONFile(path, &invites); err != nil {
+		return nil, fmt.Errorf("load invites: %w", err)
+	}
+	for _, invite := range invites {
+		i.codes[invite.Code] = invite
+	}
+	return i, nil
+}
+
+func (i *Invites) Issue(createdBy string) (*Invite, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	invite := &Invite{
+		Code:      base32.StdEncoding.EncodeToString(buf),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(inviteLifetime),
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.codes[invite.Code] = invite
+	return invite, i.save()
+}
+
+// Redeem consumes code and reports whether it was valid.
+func (i *Invites) Redeem(code string) bool {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	invite, ok := i.codes[code]
+	if !ok {
+		return false
+	}
+	delete(i.codes, code)
+	if err := i.save(); err != nil {
+		log.Error("Failed to save invites", "path", i.path, "err", err)
+	}
+	return time.Now().Before(invite.ExpiresAt)
+}
+
+func (i *Invites) save() error {
+	if i.path == "" {
+		return nil
+	}
+
+	now := time.Now()
+	invites := make([]*Invite, 0, len(i.codes))
+	for _, invite := range i.codes {
+		if now.Before(invite.ExpiresAt) {
+			invites = append(invites, invite)
+		}
+	}
+	return writeJSONFile(i.path, invites)
+}
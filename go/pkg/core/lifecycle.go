@@ -0,0 +1,295 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+)
+
+// CreatePolicy decides how new channels come into existence.
+type CreatePolicy string
+
+const (
+	// CreateOnJoin creates a channel the first time someone joins it.
+	CreateOnJoin CreatePolicy = "join"
+	// CreateExplicit only creates channels with /create.
+	CreateExplicit CreatePolicy = "command"
+	// CreateAdmin only lets server admins /create channels.
+	CreateAdmin CreatePolicy = "admin"
+)
+
+const (
+	maxChannelName = 32
+	expireInterval = time.Minute
+)
+
+var ErrArchived = errors.New("the channel is archived and read-only")
+
+// WithChannelPolicy sets how channels are created and how long a channel
+// created by /join may sit empty before it is removed. A zero expiry keeps
+// them forever.
+func WithChannelPolicy(create CreatePolicy, expiry time.Duration) HubOption {
+	return func(h *Hub) {
+		h.createPolicy = create
+		h.channelExpiry = expiry
+	}
+}
+
+// ValidChannel reports whether name can be used as a channel name.
+func ValidChannel(name string) bool {
+	if name == "" || len(name) > maxChannelName {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Channel) Archived() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.archived
+}
+
+// archived reports whether channelID is an archived channel.
+func (h *Hub) archived(channelID string) bool {
+	if IsDirect(channelID) {
+		return false
+	}
+	channel := h.findChannel(channelID)
+	return channel != nil && channel.Archived()
+}
+
+func (c *Channel) setArchived(archived bool) {
+	c.mu.Lock()
+	c.archived = archived
+	c.mu.Unlock()
+}
+
+// emptyFor reports how long the channel has had no members, as seen by the
+// periodic expiry sweep.
+func (c *Channel) emptyFor(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.members) > 0 {
+		c.emptySince = time.Time{}
+		return 0
+	}
+	if c.emptySince.IsZero() {
+		c.emptySince = now
+	}
+	return now.Sub(c.emptySince)
+}
+
+func (h *Hub) isDefaultChannel(name string) bool {
+	for _, dc := range h.defaultChannels {
+		if dc.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// canCreate reports why userID may not create channels under the policy, if
+// they may not. onJoin is set when the channel would be created by /join.
+func (h *Hub) canCreate(userID string, onJoin bool) error {
+	switch {
+	case h.createPolicy == CreateAdmin && !h.IsAdmin(userID):
+		return errors.New("only server admins can create channels")
+	case onJoin && h.createPolicy != CreateOnJoin:
+		return errors.New("no such channel, create it with /create")
+	}
+	return nil
+}
+
+// cmdCreate makes a permanent channel owned by the caller and joins it.
+func (h *Hub) cmdCreate(session *Session, name, topic string) {
+	name = normalizeChannel(name)
+	if !ValidChannel(name) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf(
+			"Cannot create #%s: use up to %d letters, digits, '-', '_' or '.'", name, maxChannelName)))
+		return
+	}
+	if err := h.canCreate(session.UserID, false); err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot create #%s: %v", name, err)))
+		return
+	}
+
+	h.mu.Lock()
+	if _, exists := h.channels[name]; exists {
+		h.mu.Unlock()
+		h.sendToSession(session, errorMessage(fmt.Sprintf("#%s already exists", name)))
+		return
+	}
+	if err := h.purgeHistory(name); err != nil {
+		h.mu.Unlock()
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot create #%s: %v", name, err)))
+		return
+	}
+	channel := h.createChannel(name, "")
+	channel.SetRole(session.UserID, RoleOwner)
+	channel.setCreator(session.Username())
+	if topic != "" {
+		channel.setTopic(topic, session.Username())
+	}
+	h.saveChannelsLocked()
+	h.mu.Unlock()
+
+	log.Info("Channel created", "channel", name, "by", session.Username())
+	h.joinChannel(session, name, "")
+}
+
+// ownChannel resolves name, or the current channel, and refuses unless the
+// caller owns it or is a server admin.
+func (h *Hub) ownChannel(session *Session, name string) *Channel {
+	if name == "" {
+		name = session.CurrentChannel
+	}
+	name = normalizeChannel(name)
+
+	channel := h.findChannel(name)
+	if channel == nil || !h.visible(channel, session.UserID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("No such channel #%s", name)))
+		return nil
+	}
+	if channel.Role(session.UserID) != RoleOwner && !h.IsAdmin(session.UserID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return nil
+	}
+	return channel
+}
+
+// cmdArchive makes a channel read-only and hides it from /list. Its history
+// stays readable.
+func (h *Hub) cmdArchive(session *Session, name string, archived bool) {
+	channel := h.ownChannel(session, name)
+	if channel == nil {
+		return
+	}
+	if archived && channel.Name == h.landingChannel() {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("#%s cannot be archived", channel.Name)))
+		return
+	}
+	if channel.Archived() == archived {
+		state := "archived"
+		if !archived {
+			state = "not archived"
+		}
+		h.sendToSession(session, errorMessage(fmt.Sprintf("#%s is %s", channel.Name, state)))
+		return
+	}
+
+	channel.setArchived(archived)
+	h.saveChannels()
+
+	text := fmt.Sprintf("%s archived #%s, it is now read-only", session.Username(), channel.Name)
+	if !archived {
+		text = fmt.Sprintf("%s restored #%s", session.Username(), channel.Name)
+	}
+	msg := systemMessage(text)
+	msg.ChannelID = channel.Name
+	channel.Broadcast(msg)
+	channel.Refresh()
+}
+
+// removeChannel drops channel and its history and moves its members
+// elsewhere. The channel stays if its history cannot be removed.
+func (h *Hub) removeChannel(channel *Channel, notice string) error {
+	h.mu.Lock()
+	if err := h.purgeHistory(channel.Name); err != nil {
+		h.mu.Unlock()
+		return err
+	}
+	delete(h.channels, channel.Name)
+	h.saveChannelsLocked()
+	h.mu.Unlock()
+
+	for _, member := range channel.Members() {
+		h.removeFromChannel(channel, member, notice)
+	}
+	return nil
+}
+
+// purgeHistory drops the stored messages of a channel that is going away, or
+// that a new channel of the same name would otherwise inherit. Callers must
+// hold h.mu.
+func (h *Hub) purgeHistory(name string) error {
+	if err := h.store.Purge(name); err != nil {
+		log.Error("Failed to purge channel history", "channel", name, "err", err)
+		return errors.New("its old history could not be removed")
+	}
+	return nil
+}
+
+// cmdDeleteChannel deletes a channel for good. Owners can delete their own
+// channels and server admins any but the landing channel.
+func (h *Hub) cmdDeleteChannel(session *Session, name string) {
+	channel := h.ownChannel(session, name)
+	if channel == nil {
+		return
+	}
+	if channel.Name == h.landingChannel() {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("#%s cannot be deleted", channel.Name)))
+		return
+	}
+
+	if err := h.removeChannel(channel, fmt.Sprintf("#%s was deleted by %s", channel.Name, session.Username())); err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot delete #%s: %v", channel.Name, err)))
+		return
+	}
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Deleted #%s", channel.Name)))
+}
+
+// expireChannels removes channels created by /join, and their history, that
+// have been empty for longer than the configured expiry.
+func (h *Hub) expireChannels() {
+	now := time.Now()
+	for _, channel := range h.allChannels() {
+		if h.isDefaultChannel(channel.Name) || channel.Archived() {
+			continue
+		}
+		channel.mu.RLock()
+		ephemeral := channel.ephemeral
+		channel.mu.RUnlock()
+
+		if !ephemeral || channel.emptyFor(now) < h.channelExpiry {
+			continue
+		}
+
+		h.mu.Lock()
+		if channel.UserCount() == 0 && h.purgeHistory(channel.Name) == nil {
+			delete(h.channels, channel.Name)
+			h.saveChannelsLocked()
+			log.Info("Expired empty channel", "channel", channel.Name)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *Hub) allChannels() []*Channel {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	channels := make([]*Channel, 0, len(h.channels))
+	for _, channel := range h.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// expiryTicks returns the channel that drives expireChannels, or nil when
+// channels never expire.
+func (h *Hub) expiryTicks() (<-chan time.Time, func()) {
+	if h.channelExpiry <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(min(expireInterval, h.channelExpiry))
+	return ticker.C, ticker.Stop
+}
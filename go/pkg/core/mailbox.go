@@ -0,0 +1,232 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// mailboxLimit caps how much mail is kept per user; the oldest goes first.
+const mailboxLimit = 200
+
+// Mail is a direct message or mention that arrived while its recipient was
+// offline. Channel is empty for direct messages.
+type Mail struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Channel string    `json:"channel,omitempty"`
+	Text    string    `json:"text"`
+	At      time.Time `json:"at"`
+	Read    bool      `json:"read,omitempty"`
+}
+
+func (m *Mail) String() string {
+	where := "direct message"
+	if m.Channel != "" {
+		where = "#" + m.Channel
+	}
+	return fmt.Sprintf("[%s] %s in %s: %s", m.At.Format("Jan 2 15:04"), m.From, where, m.Text)
+}
+
+// Mailbox queues mail for registered users by key fingerprint. An empty path
+// keeps the mail in memory only.
+type Mailbox struct {
+	path  string
+	boxes map[string][]*Mail
+	mu    sync.Mutex
+}
+
+func OpenMailbox(path string) (*Mailbox, error) {
+	m := &Mailbox{
+		path:  path,
+		boxes: make(map[string][]*Mail),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	if err := readJSONFile(path, &m.boxes); err != nil {
+		return nil, fmt.Errorf("load mailbox: %w", err)
+	}
+	return m, nil
+}
+
+func (m *Mailbox) Add(userID string, mail *Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	box := append(m.boxes[userID], mail)
+	if len(box) > mailboxLimit {
+		box = box[len(box)-mailboxLimit:]
+	}
+	m.boxes[userID] = box
+	return m.save()
+}
+
+func (m *Mailbox) List(userID string) []*Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Mail(nil), m.boxes[userID]...)
+}
+
+func (m *Mailbox) UnreadCount(userID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, mail := range m.boxes[userID] {
+		if !mail.Read {
+			n++
+		}
+	}
+	return n
+}
+
+// Read returns the mail selected by pick and marks it read. A nil pick
+// selects everything unread.
+func (m *Mailbox) Read(userID string, pick func(i int, mail *Mail) bool) []*Mail {
+	if pick == nil {
+		pick = func(_ int, mail *Mail) bool { return !mail.Read }
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var picked []*Mail
+	for i, mail := range m.boxes[userID] {
+		if pick(i, mail) {
+			picked = append(picked, mail)
+			mail.Read = true
+		}
+	}
+	if len(picked) > 0 {
+		if err := m.save(); err != nil {
+			log.Error("Failed to save mailbox", "path", m.path, "err", err)
+		}
+	}
+	return picked
+}
+
+func (m *Mailbox) Clear(userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.boxes, userID)
+	return m.save()
+}
+
+func (m *Mailbox) save() error {
+	if m.path == "" {
+		return nil
+	}
+	return writeJSONFile(m.path, m.boxes)
+}
+
+// registered reports whether userID owns a registered nickname, which is what
+// makes a user reachable while offline.
+func (h *Hub) registered(userID string) bool {
+	return len(h.registry.NicksFor(userID)) > 0
+}
+
+// queueMail files msg for userID if they are registered but not connected.
+func (h *Hub) queueMail(userID string, msg *Message) {
+	if h.findUser(userID) != nil || !h.registered(userID) {
+		return
+	}
+
+	mail := &Mail{
+		ID:   msg.ID,
+		From: msg.Username,
+		Text: msg.Text,
+		At:   msg.Timestamp,
+	}
+	if !IsDirect(msg.ChannelID) {
+		mail.Channel = msg.ChannelID
+	}
+	if err := h.mailbox.Add(userID, mail); err != nil {
+		log.Error("Failed to queue mail", "user", userID, "err", err)
+	}
+}
+
+// queueMentions files a channel message for every offline registered user it
+// mentions.
+func (h *Hub) queueMentions(msg *Message) {
+	for _, id := range msg.Mentions {
+		if id != msg.UserID && h.canRead(id, msg.ChannelID) {
+			h.queueMail(id, msg)
+		}
+	}
+}
+
+// deliverMail shows a connecting user what arrived while they were away.
+func (h *Hub) deliverMail(session *Session) {
+	unread := h.mailbox.Read(session.UserID, nil)
+	if len(unread) == 0 {
+		return
+	}
+
+	lines := make([]string, 0, len(unread)+1)
+	lines = append(lines, fmt.Sprintf("While you were away (%d):", len(unread)))
+	for _, mail := range unread {
+		lines = append(lines, mail.String())
+	}
+	h.sendToSession(session, systemMessage(strings.Join(lines, "\n")))
+}
+
+func (h *Hub) cmdMail(session *Session, args []string) {
+	sub := "list"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+
+	switch sub {
+	case "list":
+		box := h.mailbox.List(session.UserID)
+		if len(box) == 0 {
+			h.sendToSession(session, systemMessage("Your mailbox is empty"))
+			return
+		}
+		lines := []string{fmt.Sprintf("Mailbox (%d, %d unread):", len(box), h.mailbox.UnreadCount(session.UserID))}
+		for i, mail := range box {
+			marker := " "
+			if !mail.Read {
+				marker = "*"
+			}
+			lines = append(lines, fmt.Sprintf("%s%d. %s", marker, i+1, mail))
+		}
+		h.sendToSession(session, systemMessage(strings.Join(lines, "\n")))
+
+	case "read":
+		var pick func(int, *Mail) bool
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				h.sendToSession(session, errorMessage("Usage: /mail read [number]"))
+				return
+			}
+			pick = func(i int, _ *Mail) bool { return i == n-1 }
+		}
+		mails := h.mailbox.Read(session.UserID, pick)
+		if len(mails) == 0 {
+			h.sendToSession(session, systemMessage("No unread mail"))
+			return
+		}
+		lines := make([]string, 0, len(mails))
+		for _, mail := range mails {
+			lines = append(lines, mail.String())
+		}
+		h.sendToSession(session, systemMessage(strings.Join(lines, "\n")))
+
+	case "clear":
+		if err := h.mailbox.Clear(session.UserID); err != nil {
+			log.Error("Failed to save mailbox", "err", err)
+		}
+		h.sendToSession(session, systemMessage("Mailbox cleared"))
+
+	default:
+		h.sendToSession(session, errorMessage("Usage: /mail [list|read [number]|clear]"))
+	}
+}
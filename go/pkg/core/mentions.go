@@ -0,0 +1,74 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const defaultMentionsLimit = 20
+
+// resolveMentions turns the @nick mentions in text into user IDs. Nicks are
+// matched against connected users first, then registered nicknames.
+func (h *Hub) resolveMentions(text string) []string {
+	var ids []string
+	for _, nick := range parseMentions(text) {
+		id := ""
+		if u := h.findUserByNick(nick); u != nil {
+			id = u.ID
+		} else if reg, ok := h.registry.Lookup(nick); ok {
+			id = reg.Fingerprint
+		}
+		if id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// cmdMentions lists the most recent channel messages that mention the user.
+func (h *Hub) cmdMentions(session *Session, args []string) {
+	limit := defaultMentionsLimit
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			h.sendToSession(session, errorMessage("Usage: /mentions [count]"))
+			return
+		}
+		limit = n
+	}
+
+	var mentions []*Message
+	err := h.store.RangeTime(time.Time{}, time.Time{}, func(msg *Message) bool {
+		if !IsDirect(msg.ChannelID) && msg.UserID != session.UserID && msg.Mentioned(session.UserID) {
+			mentions = append(mentions, msg)
+		}
+		return true
+	})
+	if err != nil {
+		log.Error("Failed to search mentions", "err", err)
+	}
+	// Checking access takes hub locks, so it waits until the store is done.
+	mentions = slices.DeleteFunc(mentions, func(msg *Message) bool {
+		return !h.canRead(session.UserID, msg.ChannelID)
+	})
+
+	if len(mentions) == 0 {
+		h.sendToSession(session, systemMessage("Nobody has mentioned you yet"))
+		return
+	}
+	if len(mentions) > limit {
+		mentions = mentions[len(mentions)-limit:]
+	}
+
+	lines := []string{fmt.Sprintf("Recent mentions (%d):", len(mentions))}
+	for _, msg := range mentions {
+		lines = append(lines, fmt.Sprintf("[%s] %s in #%s: %s",
+			msg.Timestamp.Format("Jan 2 15:04"), msg.Username, msg.ChannelID, msg.Text))
+	}
+	h.sendToSession(session, systemMessage(strings.Join(lines, "\n")))
+}
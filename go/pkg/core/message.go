@@ -1,7 +1,11 @@
 package core
 
 import (
+	"slices"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -15,6 +19,28 @@ const (
 	MessageTypeLeave
 	MessageTypePrivate
 	MessageTypeError
+	// MessageTypeRefresh is never displayed. It tells a client that its
+	// session state (joined channels, active channel) changed.
+	MessageTypeRefresh
+	// MessageTypeEdit and MessageTypeDelete are never stored. They tell
+	// clients that the message with ID Ref changed.
+	MessageTypeEdit
+	MessageTypeDelete
+	// MessageTypeThread is never stored or displayed. It asks the client to
+	// open the thread of the message with ID Ref.
+	MessageTypeThread
+	// MessageTypeReaction is never stored. It carries the new Reactions of
+	// the message with ID Ref.
+	MessageTypeReaction
+	// MessageTypeSearch is never stored or displayed. It asks the client to
+	// show the results of the search query in Text.
+	MessageTypeSearch
+	// MessageTypeTyping is never stored or displayed. It tells the others in
+	// ChannelID that UserID is typing.
+	MessageTypeTyping
+	// MessageTypeNick is never stored. It tells the members of ChannelID
+	// that UserID changed their nickname.
+	MessageTypeNick
 )
 
 type Message struct {
@@ -24,7 +50,23 @@ type Message struct {
 	UserID    string      `json:"user_id"`
 	Username  string      `json:"username"`
 	Text      string      `json:"text"`
+	Mentions  []string    `json:"mentions,omitempty"`
+	Ref       string      `json:"ref,omitempty"`
+	ParentID  string      `json:"parent_id,omitempty"`
+	Replies   int         `json:"replies,omitempty"`
+	Reactions []Reaction  `json:"reactions,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
+	EditedAt  time.Time   `json:"edited_at,omitzero"`
+	Deleted   bool        `json:"deleted,omitempty"`
+	// Replayed marks a copy of a stored message sent again as history. It
+	// is never stored.
+	Replayed bool `json:"-"`
+}
+
+// Reaction is one emoji on a message and the users who reacted with it.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
 }
 
 func NewMessage(msgType MessageType, channelID, userID, username, text string) *Message {
@@ -38,3 +80,83 @@ func NewMessage(msgType MessageType, channelID, userID, username, text string) *
 		Timestamp: time.Now(),
 	}
 }
+
+// shortIDLength is how many characters of a message ID are shown to users.
+const shortIDLength = 8
+
+// ShortID is the abbreviated message ID shown to users and accepted by
+// commands that refer to a message.
+func (m *Message) ShortID() string {
+	if len(m.ID) < shortIDLength {
+		return m.ID
+	}
+	return m.ID[:shortIDLength]
+}
+
+// Mentioned reports whether userID is among the users the message mentions.
+func (m *Message) Mentioned(userID string) bool {
+	return slices.Contains(m.Mentions, userID)
+}
+
+// replayed returns a copy of the stored msg marked as replayed history.
+func replayed(msg *Message) *Message {
+	replay := *msg
+	replay.Replayed = true
+	return &replay
+}
+
+func systemMessage(text string) *Message {
+	return NewMessage(MessageTypeSystem, "", "system", "System", text)
+}
+
+func errorMessage(text string) *Message {
+	return NewMessage(MessageTypeError, "", "system", "System", text)
+}
+
+func refreshMessage() *Message {
+	return NewMessage(MessageTypeRefresh, "", "system", "System", "")
+}
+
+// parseMentions returns the nicknames mentioned as @nick in text, each once.
+func parseMentions(text string) []string {
+	var nicks []string
+	seen := make(map[string]bool)
+	for _, span := range FindMentions(text) {
+		nick := text[span[0]+1 : span[1]]
+		if !seen[nickKey(nick)] {
+			seen[nickKey(nick)] = true
+			nicks = append(nicks, nick)
+		}
+	}
+	return nicks
+}
+
+// FindMentions returns the byte ranges of the @nick mentions in text, '@'
+// included. A mention starts at a word boundary, so foo@host is not one, and
+// takes the longest valid nickname after the '@', so @bob's mentions bob.
+func FindMentions(text string) [][]int {
+	var spans [][]int
+	for i := 0; i < len(text); i++ {
+		if text[i] != '@' {
+			continue
+		}
+		if prev, _ := utf8.DecodeLastRuneInString(text[:i]); i > 0 && inWord(prev) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(text) && end-i-1 < maxNickLength && isNickChar(rune(text[end])) {
+			end++
+		}
+		if end > i+1 {
+			spans = append(spans, []int{i, end})
+			i = end - 1
+		}
+	}
+	return spans
+}
+
+// inWord reports whether r continues a word, like the "foo" in foo@host.
+func inWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.@", r)
+}
@@ -0,0 +1,428 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Role int
+
+const (
+	RoleMember Role = iota
+	RoleVoiced
+	RoleOperator
+	RoleOwner
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleOwner:
+		return "owner"
+	case RoleOperator:
+		return "operator"
+	case RoleVoiced:
+		return "voiced"
+	default:
+		return "member"
+	}
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "owner":
+		*r = RoleOwner
+	case "operator":
+		*r = RoleOperator
+	case "voiced":
+		*r = RoleVoiced
+	case "member":
+		*r = RoleMember
+	default:
+		return fmt.Errorf("unknown role %q", text)
+	}
+	return nil
+}
+
+// Prefix is the IRC-style marker shown in front of a nickname.
+func (r Role) Prefix() string {
+	switch r {
+	case RoleOwner:
+		return "~"
+	case RoleOperator:
+		return "@"
+	case RoleVoiced:
+		return "+"
+	default:
+		return ""
+	}
+}
+
+var (
+	ErrBanned       = errors.New("you are banned from this channel")
+	ErrNotPermitted = errors.New("you do not have permission to do that")
+	ErrMuted        = errors.New("you are muted")
+	ErrModerated    = errors.New("the channel is moderated, only voiced users may speak")
+)
+
+type Ban struct {
+	Fingerprint string    `json:"fingerprint"`
+	Nick        string    `json:"nick,omitempty"`
+	By          string    `json:"by"`
+	Reason      string    `json:"reason,omitempty"`
+	Until       time.Time `json:"until,omitzero"`
+}
+
+func (b *Ban) Active(now time.Time) bool {
+	return b.Until.IsZero() || now.Before(b.Until)
+}
+
+func (b *Ban) String() string {
+	target := b.Fingerprint
+	if b.Nick != "" {
+		target = fmt.Sprintf("%s (%s)", b.Nick, b.Fingerprint)
+	}
+	expiry := "permanent"
+	if !b.Until.IsZero() {
+		expiry = "until " + b.Until.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprintf("%s by %s, %s%s", target, b.By, expiry, formatReason(b.Reason))
+}
+
+// parseDuration extends time.ParseDuration with a "d" suffix for days.
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func (c *Channel) Role(userID string) Role {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.roles[userID]
+}
+
+func (c *Channel) SetRole(userID string, role Role) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if role == RoleMember {
+		delete(c.roles, userID)
+		return
+	}
+	c.roles[userID] = role
+}
+
+func (c *Channel) Ban(ban *Ban) {
+	c.mu.Lock()
+	c.bans[ban.Fingerprint] = ban
+	delete(c.members, ban.Fingerprint)
+	delete(c.invites, ban.Fingerprint)
+	c.mu.Unlock()
+}
+
+func (c *Channel) Unban(fingerprint string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.bans[fingerprint]
+	delete(c.bans, fingerprint)
+	return ok
+}
+
+func (c *Channel) Bans() []*Ban {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	bans := make([]*Ban, 0, len(c.bans))
+	for _, ban := range c.bans {
+		if ban.Active(now) {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// Mute silences userID until the given time; a zero time mutes indefinitely.
+func (c *Channel) Mute(userID string, until time.Time) {
+	c.mu.Lock()
+	c.mutes[userID] = until
+	c.mu.Unlock()
+}
+
+func (c *Channel) Unmute(userID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.mutes[userID]
+	delete(c.mutes, userID)
+	return ok
+}
+
+// CheckSpeak reports why userID may not send to the channel, if they may not.
+func (c *Channel) CheckSpeak(userID string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.archived {
+		return ErrArchived
+	}
+	if until, muted := c.mutes[userID]; muted && (until.IsZero() || time.Now().Before(until)) {
+		return ErrMuted
+	}
+	if c.modes.Moderated && c.roles[userID] < RoleVoiced {
+		return ErrModerated
+	}
+	return nil
+}
+
+// Announce delivers a system notice to every member without storing it.
+func (c *Channel) Announce(text string) {
+	msg := systemMessage(text)
+	msg.ChannelID = c.Name
+	c.Send(msg)
+}
+
+// moderate resolves the acting user's role in the current channel and
+// refuses unless it is at least minRole.
+func (h *Hub) moderate(session *Session, minRole Role) *Channel {
+	channel := h.currentChannel(session)
+	if channel == nil {
+		h.sendToSession(session, errorMessage("You are not in a channel"))
+		return nil
+	}
+	if channel.Role(session.UserID) < minRole && !h.IsAdmin(session.UserID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return nil
+	}
+	return channel
+}
+
+// outranks reports whether the actor may moderate the target in channel.
+// Server admins outrank everyone and cannot be moderated by others; in the
+// channel it takes a strictly higher role, so owners cannot act on each other.
+func (h *Hub) outranks(channel *Channel, actorID, targetID string) bool {
+	if h.IsAdmin(actorID) {
+		return true
+	}
+	if h.IsAdmin(targetID) {
+		return false
+	}
+	return channel.Role(actorID) > channel.Role(targetID)
+}
+
+// resolveTarget maps a nickname or raw fingerprint to a fingerprint, looking
+// at connected users first and the nickname registry second.
+func (h *Hub) resolveTarget(target string) (fingerprint, nick string, ok bool) {
+	if u := h.findUserByNick(target); u != nil {
+		return u.ID, u.Username(), true
+	}
+	if reg, found := h.registry.Lookup(target); found {
+		return reg.Fingerprint, reg.Nick, true
+	}
+	if strings.HasPrefix(target, "SHA256:") {
+		return target, "", true
+	}
+	return "", "", false
+}
+
+func (h *Hub) cmdKick(session *Session, target, reason string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	user := h.findUserByNick(target)
+	if user == nil || !channel.HasMember(user.ID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("%s is not in #%s", target, channel.Name)))
+		return
+	}
+	if !h.outranks(channel, session.UserID, user.ID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return
+	}
+
+	if channel.uninvite(user.ID) {
+		h.saveChannels()
+	}
+	h.removeFromChannel(channel, user, fmt.Sprintf("You were kicked from #%s by %s%s",
+		channel.Name, session.Username(), formatReason(reason)))
+	channel.Announce(fmt.Sprintf("%s was kicked by %s%s", user.Username(), session.Username(), formatReason(reason)))
+}
+
+func (h *Hub) cmdBan(session *Session, args []string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	fingerprint, nick, ok := h.resolveTarget(args[0])
+	if !ok {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown user %s", args[0])))
+		return
+	}
+	if !h.outranks(channel, session.UserID, fingerprint) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return
+	}
+
+	ban := &Ban{
+		Fingerprint: fingerprint,
+		Nick:        nick,
+		By:          session.Username(),
+	}
+	rest := args[1:]
+	if len(rest) > 0 {
+		if d, err := parseDuration(rest[0]); err == nil {
+			ban.Until = time.Now().Add(d)
+			rest = rest[1:]
+		}
+	}
+	ban.Reason = strings.Join(rest, " ")
+
+	channel.Ban(ban)
+	h.saveChannels()
+
+	if user := h.findUser(fingerprint); user != nil {
+		h.removeFromChannel(channel, user, fmt.Sprintf("You were banned from #%s by %s%s",
+			channel.Name, session.Username(), formatReason(ban.Reason)))
+	}
+	channel.Announce(fmt.Sprintf("Banned %s", ban))
+}
+
+func (h *Hub) cmdUnban(session *Session, target string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	fingerprint, _, ok := h.resolveTarget(target)
+	if !ok || !channel.Unban(fingerprint) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("%s is not banned from #%s", target, channel.Name)))
+		return
+	}
+	h.saveChannels()
+	channel.Announce(fmt.Sprintf("%s was unbanned by %s", target, session.Username()))
+}
+
+func (h *Hub) cmdBans(session *Session) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	bans := channel.Bans()
+	if len(bans) == 0 {
+		h.sendToSession(session, systemMessage(fmt.Sprintf("No bans in #%s", channel.Name)))
+		return
+	}
+
+	lines := make([]string, 0, len(bans))
+	for _, ban := range bans {
+		lines = append(lines, ban.String())
+	}
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Bans in #%s:\n%s", channel.Name, strings.Join(lines, "\n"))))
+}
+
+func (h *Hub) cmdMute(session *Session, args []string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	user := h.findUserByNick(args[0])
+	if user == nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("User %s not found", args[0])))
+		return
+	}
+	if !h.outranks(channel, session.UserID, user.ID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return
+	}
+
+	var until time.Time
+	if len(args) > 1 {
+		d, err := parseDuration(args[1])
+		if err != nil {
+			h.sendToSession(session, errorMessage(err.Error()))
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	channel.Mute(user.ID, until)
+	h.saveChannels()
+	channel.Announce(fmt.Sprintf("%s was muted by %s", user.Username(), session.Username()))
+}
+
+func (h *Hub) cmdUnmute(session *Session, target string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	user := h.findUserByNick(target)
+	if user == nil || !channel.Unmute(user.ID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("%s is not muted", target)))
+		return
+	}
+	h.saveChannels()
+	channel.Announce(fmt.Sprintf("%s was unmuted by %s", user.Username(), session.Username()))
+}
+
+func (h *Hub) cmdSetRole(session *Session, target string, role Role) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	user := h.findUserByNick(target)
+	if user == nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("User %s not found", target)))
+		return
+	}
+	if user.ID != session.UserID && !h.outranks(channel, session.UserID, user.ID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return
+	}
+	if channel.Role(user.ID) == RoleOwner {
+		h.sendToSession(session, errorMessage("The channel owner's role cannot be changed"))
+		return
+	}
+
+	channel.SetRole(user.ID, role)
+	h.saveChannels()
+	channel.Refresh()
+	channel.Announce(fmt.Sprintf("%s is now %s in #%s (set by %s)",
+		user.Username(), role, channel.Name, session.Username()))
+}
+
+// removeFromChannel takes user out of channel and tells them why.
+func (h *Hub) removeFromChannel(channel *Channel, user *User, notice string) {
+	channel.RemoveMember(user.ID)
+	if user.InChannel(channel.Name) {
+		user.Deliver(errorMessage(notice))
+		h.leaveChannel(user, channel.Name)
+	}
+	channel.Refresh()
+}
+
+func formatReason(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ": " + reason
+}
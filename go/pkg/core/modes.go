@@ -0,0 +1,312 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInviteOnly = errors.New("the channel is invite-only")
+	ErrBadKey     = errors.New("the channel needs the right key, use /join #channel <key>")
+)
+
+// Modes are the IRC-style flags of a channel.
+type Modes struct {
+	InviteOnly bool   `json:"invite_only,omitempty"` // +i: joining needs an /invite
+	Moderated  bool   `json:"moderated,omitempty"`   // +m: only voiced members may speak
+	Secret     bool   `json:"secret,omitempty"`      // +s: hidden from /list for non-members
+	Key        string `json:"key,omitempty"`         // +k: joining needs the key
+	TopicLock  bool   `json:"topic_lock,omitempty"`  // +t: only operators may set the topic
+}
+
+// String lists the set flags, e.g. "+imt". The key itself is never shown.
+func (m Modes) String() string {
+	var b strings.Builder
+	for _, flag := range []struct {
+		letter byte
+		set    bool
+	}{
+		{'i', m.InviteOnly},
+		{'m', m.Moderated},
+		{'s', m.Secret},
+		{'k', m.Key != ""},
+		{'t', m.TopicLock},
+	} {
+		if flag.set {
+			b.WriteByte(flag.letter)
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "+" + b.String()
+}
+
+// apply changes modes by specs such as "+mt", "-i" or "+k secret". Each +k
+// takes the next argument as the key.
+func (m Modes) apply(args []string) (Modes, error) {
+	for len(args) > 0 {
+		spec := args[0]
+		args = args[1:]
+		if spec == "" || spec[0] != '+' && spec[0] != '-' {
+			return m, fmt.Errorf("invalid mode change %q, use e.g. +m or -t", spec)
+		}
+
+		set := true
+		for _, r := range spec {
+			switch r {
+			case '+':
+				set = true
+			case '-':
+				set = false
+			case 'i':
+				m.InviteOnly = set
+			case 'm':
+				m.Moderated = set
+			case 's':
+				m.Secret = set
+			case 't':
+				m.TopicLock = set
+			case 'k':
+				if !set {
+					m.Key = ""
+					continue
+				}
+				if len(args) == 0 {
+					return m, errors.New("+k needs a key")
+				}
+				m.Key, args = args[0], args[1:]
+			default:
+				return m, fmt.Errorf("unknown mode %c", r)
+			}
+		}
+	}
+	return m, nil
+}
+
+// ChannelInfo describes a channel's metadata for display.
+type ChannelInfo struct {
+	Name        string
+	Topic       string
+	TopicBy     string
+	TopicAt     time.Time
+	Description string
+	Modes       Modes
+	CreatedBy   string
+	CreatedAt   time.Time
+	Archived    bool
+	Members     int
+}
+
+func (c *Channel) Topic() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.topic
+}
+
+func (c *Channel) Modes() Modes {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modes
+}
+
+func (c *Channel) Info() ChannelInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return ChannelInfo{
+		Name:        c.Name,
+		Topic:       c.topic,
+		TopicBy:     c.topicBy,
+		TopicAt:     c.topicAt,
+		Description: c.description,
+		Modes:       c.modes,
+		CreatedBy:   c.createdBy,
+		CreatedAt:   c.createdAt,
+		Archived:    c.archived,
+		Members:     len(c.members),
+	}
+}
+
+func (c *Channel) setTopic(topic, by string) {
+	c.mu.Lock()
+	c.topic, c.topicBy, c.topicAt = topic, by, time.Now()
+	c.mu.Unlock()
+}
+
+func (c *Channel) setDescription(description string) {
+	c.mu.Lock()
+	c.description = description
+	c.mu.Unlock()
+}
+
+func (c *Channel) setModes(modes Modes) {
+	c.mu.Lock()
+	c.modes = modes
+	c.mu.Unlock()
+}
+
+func (c *Channel) setCreator(by string) {
+	c.mu.Lock()
+	c.createdBy = by
+	c.mu.Unlock()
+}
+
+// checkJoin enforces +i and +k. Invited users, anyone with a role in the
+// channel and server admins get in regardless.
+func (h *Hub) checkJoin(channel *Channel, userID, key string) error {
+	if channel.Allowed(userID) || h.IsAdmin(userID) {
+		return nil
+	}
+	modes := channel.Modes()
+	if modes.InviteOnly {
+		return ErrInviteOnly
+	}
+	if modes.Key != "" && key != modes.Key {
+		return ErrBadKey
+	}
+	return nil
+}
+
+// ChannelInfo returns the metadata of the named channel.
+func (h *Hub) ChannelInfo(name string) (ChannelInfo, bool) {
+	channel := h.findChannel(name)
+	if channel == nil {
+		return ChannelInfo{}, false
+	}
+	return channel.Info(), true
+}
+
+// cmdTopic shows the current channel's topic, or sets it. With +t set only
+// operators may change it.
+func (h *Hub) cmdTopic(session *Session, topic string) {
+	channel := h.currentChannel(session)
+	if channel == nil {
+		h.sendToSession(session, errorMessage("You are not in a channel"))
+		return
+	}
+
+	if topic == "" {
+		info := channel.Info()
+		if info.Topic == "" {
+			h.sendToSession(session, systemMessage(fmt.Sprintf("#%s has no topic", info.Name)))
+			return
+		}
+		text := fmt.Sprintf("Topic of #%s: %s", info.Name, info.Topic)
+		if info.TopicBy != "" {
+			text += fmt.Sprintf("\nSet by %s on %s", info.TopicBy, info.TopicAt.Format("2006-01-02 15:04"))
+		}
+		h.sendToSession(session, systemMessage(text))
+		return
+	}
+
+	minRole := RoleMember
+	if channel.Modes().TopicLock {
+		minRole = RoleOperator
+	}
+	if channel = h.moderate(session, minRole); channel == nil {
+		return
+	}
+
+	if topic == "-" {
+		topic = ""
+	}
+	channel.setTopic(topic, session.Username())
+	h.saveChannels()
+
+	text := fmt.Sprintf("%s changed the topic to: %s", session.Username(), topic)
+	if topic == "" {
+		text = fmt.Sprintf("%s cleared the topic", session.Username())
+	}
+	msg := systemMessage(text)
+	msg.ChannelID = channel.Name
+	channel.Broadcast(msg)
+	channel.Refresh()
+}
+
+// cmdMode shows the current channel's modes, or changes them.
+func (h *Hub) cmdMode(session *Session, args []string) {
+	if len(args) == 0 {
+		channel := h.currentChannel(session)
+		if channel == nil {
+			h.sendToSession(session, errorMessage("You are not in a channel"))
+			return
+		}
+		modes := channel.Modes().String()
+		if modes == "" {
+			modes = "none"
+		}
+		h.sendToSession(session, systemMessage(fmt.Sprintf("Modes of #%s: %s", channel.Name, modes)))
+		return
+	}
+
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	modes, err := channel.Modes().apply(args)
+	if err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot change modes: %v", err)))
+		return
+	}
+
+	channel.setModes(modes)
+	h.saveChannels()
+
+	shown := modes.String()
+	if shown == "" {
+		shown = "none"
+	}
+	channel.Announce(fmt.Sprintf("%s set the modes of #%s to %s", session.Username(), channel.Name, shown))
+	channel.Refresh()
+}
+
+func (h *Hub) cmdDescription(session *Session, description string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	channel.setDescription(description)
+	h.saveChannels()
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Updated the description of #%s", channel.Name)))
+}
+
+// cmdInfo shows a channel's metadata. Secret and private channels are only
+// described to their members.
+func (h *Hub) cmdInfo(session *Session, name string) {
+	if name == "" {
+		name = session.CurrentChannel
+	}
+	name = normalizeChannel(name)
+
+	channel := h.findChannel(name)
+	if channel == nil || !h.visible(channel, session.UserID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("No such channel #%s", name)))
+		return
+	}
+
+	info := channel.Info()
+	lines := []string{"#" + info.Name}
+	if info.Topic != "" {
+		lines = append(lines, "Topic: "+info.Topic)
+	}
+	if info.Description != "" {
+		lines = append(lines, "Description: "+info.Description)
+	}
+	if modes := info.Modes.String(); modes != "" {
+		lines = append(lines, "Modes: "+modes)
+	}
+	if info.Archived {
+		lines = append(lines, "Archived, read-only")
+	}
+	created := "Created " + info.CreatedAt.Format("2006-01-02")
+	if info.CreatedBy != "" {
+		created += " by " + info.CreatedBy
+	}
+	lines = append(lines, created, fmt.Sprintf("%d member(s)", info.Members))
+
+	h.sendToSession(session, systemMessage(strings.Join(lines, "\n")))
+}
@@ -0,0 +1,327 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Presence is what other users see of someone's availability.
+type Presence int
+
+const (
+	PresenceOffline Presence = iota
+	PresenceOnline
+	PresenceIdle
+	PresenceAway
+)
+
+const presenceInterval = 30 * time.Second
+
+func (p Presence) String() string {
+	switch p {
+	case PresenceOnline:
+		return "online"
+	case PresenceIdle:
+		return "idle"
+	case PresenceAway:
+		return "away"
+	default:
+		return "offline"
+	}
+}
+
+// WithIdleAfter sets how long a user may go without input before they show
+// as idle. Zero turns idle detection off.
+func WithIdleAfter(d time.Duration) HubOption {
+	return func(h *Hub) {
+		h.idleAfter = d
+	}
+}
+
+// WithLastSeen sets where last-seen times of disconnected users are kept.
+func WithLastSeen(path string) HubOption {
+	return func(h *Hub) {
+		h.lastSeenPath = path
+	}
+}
+
+// seenRecord is what is kept of a user between connections: when the last
+// one went away, and whether they were away at the time.
+type seenRecord struct {
+	At          time.Time `json:"at"`
+	Away        bool      `json:"away,omitempty"`
+	AwayMessage string    `json:"away_message,omitempty"`
+
+	// replied holds who was told about the away message while the user
+	// was offline.
+	replied map[string]bool
+}
+
+func (r *seenRecord) UnmarshalJSON(data []byte) error {
+	// Older files hold just the time.
+	if err := json.Unmarshal(data, &r.At); err == nil {
+		return nil
+	}
+	type record seenRecord
+	return json.Unmarshal(data, (*record)(r))
+}
+
+// Touch records input from the user, which ends being idle.
+func (u *User) Touch() {
+	u.mu.Lock()
+	u.lastActive = time.Now()
+	u.mu.Unlock()
+}
+
+// Touch records input on the session.
+func (s *Session) Touch() {
+	if s.user != nil {
+		s.user.Touch()
+	}
+}
+
+func (u *User) setAway(message string) {
+	u.mu.Lock()
+	u.isAway, u.away = true, message
+	u.awayReplied = make(map[string]bool)
+	u.mu.Unlock()
+}
+
+// Away returns the user's away message, if they are away.
+func (u *User) Away() (string, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.away, u.isAway
+}
+
+func (u *User) back() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	wasAway := u.isAway
+	u.isAway, u.away, u.awayReplied = false, "", nil
+	return wasAway
+}
+
+// Presence works out the user's presence. idleAfter of zero never idles.
+func (u *User) Presence(idleAfter time.Duration) Presence {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	switch {
+	case len(u.sessions) == 0:
+		return PresenceOffline
+	case u.isAway:
+		return PresenceAway
+	case idleAfter > 0 && time.Since(u.lastActive) >= idleAfter:
+		return PresenceIdle
+	}
+	return PresenceOnline
+}
+
+func (u *User) IdleFor() time.Duration {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return time.Since(u.lastActive)
+}
+
+// notePresence remembers the presence others were last shown and reports
+// whether it changed.
+func (u *User) notePresence(p Presence) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	changed := u.shown != p
+	u.shown = p
+	return changed
+}
+
+// replyAway reports whether someone writing to the away user should be told,
+// which happens once per sender for every time they go away.
+func (u *User) replyAway(senderID string) (string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.isAway || u.awayReplied[senderID] {
+		return "", false
+	}
+	u.awayReplied[senderID] = true
+	return u.away, true
+}
+
+// Presence describes userID's presence, e.g. "away: lunch", "idle 12m" or
+// "offline, last seen 2006-01-02 15:04".
+func (h *Hub) Presence(userID string) (Presence, string) {
+	user := h.findUser(userID)
+	if user == nil {
+		seen, ok := h.lastSeenAt(userID)
+		if !ok {
+			return PresenceOffline, "offline"
+		}
+		return PresenceOffline, "offline, last seen " + formatSeen(seen)
+	}
+
+	p := user.Presence(h.idleAfter)
+	switch p {
+	case PresenceAway:
+		if message, _ := user.Away(); message != "" {
+			return p, "away: " + message
+		}
+	case PresenceIdle:
+		return p, "idle " + formatAgo(user.IdleFor())
+	}
+	return p, p.String()
+}
+
+// lastSeenAt returns when userID's last connection went away, if known.
+func (h *Hub) lastSeenAt(userID string) (time.Time, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	seen, ok := h.lastSeen[userID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return seen.At, true
+}
+
+// formatSeen describes a last seen time, e.g. "2024-05-01 14:03 (3h ago)".
+func formatSeen(seen time.Time) string {
+	return fmt.Sprintf("%s (%s ago)", seen.Format("2006-01-02 15:04"), formatAgo(time.Since(seen)))
+}
+
+// formatAgo rounds d to the largest sensible unit, e.g. "3h" or "12m".
+func formatAgo(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return "<1m"
+}
+
+// refreshPresence tells everyone who can see user that their presence
+// changed.
+func (h *Hub) refreshPresence(user *User) {
+	for _, name := range user.Channels() {
+		if channel := h.findChannel(name); channel != nil {
+			channel.Refresh()
+		}
+	}
+	for _, conv := range h.conversationsFor(user.ID) {
+		if peer := h.findUser(conv.Peer(user.ID)); peer != nil {
+			peer.Refresh()
+		}
+	}
+}
+
+// sweepPresence picks up users who went idle or came back from being idle.
+func (h *Hub) sweepPresence() {
+	for _, user := range h.allUsers() {
+		if user.notePresence(user.Presence(h.idleAfter)) {
+			h.refreshPresence(user)
+		}
+	}
+}
+
+func (h *Hub) loadLastSeen() {
+	if h.lastSeenPath == "" {
+		return
+	}
+	if err := readJSONFile(h.lastSeenPath, &h.lastSeen); err != nil {
+		log.Error("Failed to load last seen times", "path", h.lastSeenPath, "err", err)
+	}
+	if h.lastSeen == nil {
+		h.lastSeen = make(map[string]*seenRecord)
+	}
+}
+
+// saveLastSeenLocked persists last-seen times. Callers must hold h.mu.
+func (h *Hub) saveLastSeenLocked() {
+	if h.lastSeenPath == "" {
+		return
+	}
+
+	h.saveMu.Lock()
+	defer h.saveMu.Unlock()
+	if err := writeJSONFile(h.lastSeenPath, h.lastSeen); err != nil {
+		log.Error("Failed to save last seen times", "path", h.lastSeenPath, "err", err)
+	}
+}
+
+func (h *Hub) cmdAway(session *Session, message string) {
+	user := session.User()
+	user.setAway(message)
+	user.notePresence(PresenceAway)
+	h.refreshPresence(user)
+
+	text := "You are now away"
+	if message != "" {
+		text += ": " + message
+	}
+	h.sendToSession(session, systemMessage(text))
+}
+
+func (h *Hub) cmdBack(session *Session) {
+	user := session.User()
+	if !user.back() {
+		h.sendToSession(session, errorMessage("You are not away"))
+		return
+	}
+	user.Touch()
+	user.notePresence(user.Presence(h.idleAfter))
+	h.refreshPresence(user)
+	h.sendToSession(session, systemMessage("Welcome back"))
+}
+
+// replyAwayDirect tells the sender of a direct message that its recipient is
+// away.
+func (h *Hub) replyAwayDirect(session *Session, conv *Conversation) {
+	peerID := conv.Peer(session.UserID)
+	if peerID == session.UserID {
+		return
+	}
+	name, message, ok := conv.Name(peerID), "", false
+	if peer := h.findUser(peerID); peer != nil {
+		name = peer.Username()
+		message, ok = peer.replyAway(session.UserID)
+	} else {
+		message, ok = h.replyAwayOffline(peerID, session.UserID)
+	}
+	if !ok {
+		return
+	}
+
+	text := name + " is away"
+	if message != "" {
+		text += ": " + message
+	}
+	// Shown wherever the sender is looking, like the reply to /dm.
+	h.sendToSession(session, systemMessage(text))
+}
+
+// replyAwayOffline is replyAway for a user who left while away.
+func (h *Hub) replyAwayOffline(userID, senderID string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen, ok := h.lastSeen[userID]
+	if !ok || !seen.Away || seen.replied[senderID] {
+		return "", false
+	}
+	if seen.replied == nil {
+		seen.replied = make(map[string]bool)
+	}
+	seen.replied[senderID] = true
+	return seen.AwayMessage, true
+}
+
+// presenceSuffix annotates a nickname in listings, e.g. " (away: lunch)".
+func (h *Hub) presenceSuffix(userID string) string {
+	p, detail := h.Presence(userID)
+	if p == PresenceOnline {
+		return ""
+	}
+	return " (" + detail + ")"
+}
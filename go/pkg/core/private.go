@@ -0,0 +1,106 @@
+package core
+
+import "fmt"
+
+// Private reports whether the channel is closed to the public: invite-only
+// or protected by a key. Private channels are hidden from non-members and
+// only members may read their history.
+func (m Modes) Private() bool {
+	return m.InviteOnly || m.Key != ""
+}
+
+// Hidden reports whether the channel is left out of /list for non-members.
+func (m Modes) Hidden() bool {
+	return m.Secret || m.Private()
+}
+
+func (c *Channel) invite(userID, by string) {
+	c.mu.Lock()
+	c.invites[userID] = by
+	c.mu.Unlock()
+}
+
+func (c *Channel) uninvite(userID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.invites[userID]
+	delete(c.invites, userID)
+	return ok
+}
+
+// Allowed reports whether userID belongs to the channel: they are in it,
+// were invited, or hold a role in it.
+func (c *Channel) Allowed(userID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if _, ok := c.members[userID]; ok {
+		return true
+	}
+	if _, ok := c.invites[userID]; ok {
+		return true
+	}
+	return c.roles[userID] > RoleMember
+}
+
+// visible reports whether userID may know that channel exists.
+func (h *Hub) visible(channel *Channel, userID string) bool {
+	return !channel.Modes().Hidden() || channel.Allowed(userID) || h.IsAdmin(userID)
+}
+
+// cmdInvite lets userID into an invite-only channel and tells them about it.
+// Inviting to a private channel takes an operator.
+func (h *Hub) cmdInvite(session *Session, target, name string) {
+	channel := h.currentChannel(session)
+	if name != "" {
+		channel = h.findChannel(normalizeChannel(name))
+	}
+	if channel == nil || !channel.HasMember(session.UserID) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("You are not in #%s", normalizeChannel(name))))
+		return
+	}
+	if channel.Modes().Private() && channel.Role(session.UserID) < RoleOperator && !h.IsAdmin(session.UserID) {
+		h.sendToSession(session, errorMessage(ErrNotPermitted.Error()))
+		return
+	}
+
+	fingerprint, nick, ok := h.resolveTarget(target)
+	if !ok {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown user %s", target)))
+		return
+	}
+	if nick == "" {
+		nick = shortFingerprint(fingerprint)
+	}
+	if channel.HasMember(fingerprint) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("%s is already in #%s", nick, channel.Name)))
+		return
+	}
+
+	channel.invite(fingerprint, session.Username())
+	h.saveChannels()
+
+	notice := NewMessage(MessageTypeSystem, "", session.UserID, session.Username(),
+		fmt.Sprintf("%s invited you to #%s, type /join #%s to join", session.Username(), channel.Name, channel.Name))
+	if user := h.findUser(fingerprint); user != nil {
+		user.Deliver(notice)
+	} else {
+		h.queueMail(fingerprint, notice)
+	}
+	channel.Announce(fmt.Sprintf("%s invited %s", session.Username(), nick))
+}
+
+// cmdUninvite takes back an invite to the current channel.
+func (h *Hub) cmdUninvite(session *Session, target string) {
+	channel := h.moderate(session, RoleOperator)
+	if channel == nil {
+		return
+	}
+
+	fingerprint, _, ok := h.resolveTarget(target)
+	if !ok || !channel.uninvite(fingerprint) {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("%s is not invited to #%s", target, channel.Name)))
+		return
+	}
+	h.saveChannels()
+	h.sendToSession(session, systemMessage(fmt.Sprintf("Took back the invite of %s to #%s", target, channel.Name)))
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+)
+
+var shortcodes = map[string]string{
+	"+1":               "👍",
+	"thumbsup":         "👍",
+	"-1":               "👎",
+	"thumbsdown":       "👎",
+	"heart":            "❤️",
+	"smile":            "😄",
+	"joy":              "😂",
+	"laughing":         "😆",
+	"tada":             "🎉",
+	"eyes":             "👀",
+	"fire":             "🔥",
+	"rocket":           "🚀",
+	"white_check_mark": "✅",
+	"check":            "✅",
+	"x":                "❌",
+	"thinking":         "🤔",
+	"pray":             "🙏",
+	"wave":             "👋",
+	"ok_hand":          "👌",
+	"clap":             "👏",
+	"100":              "💯",
+	"sob":              "😭",
+	"warning":          "⚠️",
+}
+
+// parseEmoji accepts an emoji or a :shortcode:, with or without the colons.
+func parseEmoji(s string) (string, bool) {
+	code := strings.Trim(s, ":")
+	if emoji, ok := shortcodes[strings.ToLower(code)]; ok {
+		return emoji, true
+	}
+
+	// Anything else has to be a short emoji sequence: pictographs, possibly
+	// joined or modified, starting with a pictograph.
+	if s == "" || utf8.RuneCountInString(s) > 8 {
+		return "", false
+	}
+	for i, r := range s {
+		switch {
+		case isPictograph(r):
+		case i > 0 && isEmojiModifier(r):
+		default:
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// isPictograph reports whether r is in one of the blocks emoji come from.
+func isPictograph(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // Pictographs, emoticons, flags, ...
+	case r >= 0x2600 && r <= 0x27BF: // Miscellaneous symbols, dingbats
+	case r >= 0x2300 && r <= 0x23FF: // Miscellaneous technical, e.g. ⌚
+	case r >= 0x2190 && r <= 0x21FF: // Arrows
+	case r >= 0x2B00 && r <= 0x2BFF: // e.g. ⭐
+	case r >= 0x25A0 && r <= 0x25FF: // Geometric shapes
+	case r == 0x00A9, r == 0x00AE, r == 0x203C, r == 0x2049, r == 0x2122,
+		r == 0x2139, r == 0x24C2, r == 0x3030, r == 0x303D, r == 0x3297, r == 0x3299:
+	default:
+		return false
+	}
+	return true
+}
+
+// isEmojiModifier reports whether r changes the emoji before it: a zero
+// width joiner, a variation selector, a keycap or a tag.
+func isEmojiModifier(r rune) bool {
+	return r == 0x200D || r == 0xFE0E || r == 0xFE0F || r == 0x20E3 || r >= 0xE0020 && r <= 0xE007F
+}
+
+// toggleReaction adds userID's emoji to reactions, or takes it away if it is
+// already there.
+func toggleReaction(reactions []Reaction, emoji, userID string) []Reaction {
+	reactions = slices.Clone(reactions)
+	for i, r := range reactions {
+		if r.Emoji != emoji {
+			continue
+		}
+		if slices.Contains(r.Users, userID) {
+			r.Users = slices.DeleteFunc(slices.Clone(r.Users), func(id string) bool { return id == userID })
+		} else {
+			r.Users = append(slices.Clone(r.Users), userID)
+		}
+		if len(r.Users) == 0 {
+			return slices.Delete(reactions, i, i+1)
+		}
+		reactions[i] = r
+		return reactions
+	}
+	return append(reactions, Reaction{Emoji: emoji, Users: []string{userID}})
+}
+
+func (h *Hub) cmdReact(session *Session, ref, code string) {
+	emoji, ok := parseEmoji(code)
+	if !ok {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Unknown emoji %s", code)))
+		return
+	}
+
+	h.updateMu.Lock()
+	defer h.updateMu.Unlock()
+
+	orig, err := h.findMessage(session, ref)
+	if err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot react: %v", err)))
+		return
+	}
+	if !IsDirect(orig.ChannelID) {
+		channel := h.findChannel(orig.ChannelID)
+		if channel == nil {
+			h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot react: #%s no longer exists", orig.ChannelID)))
+			return
+		}
+		if err := channel.CheckSpeak(session.UserID); err != nil {
+			h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot react: %v", err)))
+			return
+		}
+	}
+
+	reacted := *orig
+	reacted.Reactions = toggleReaction(orig.Reactions, emoji, session.UserID)
+	if err := h.store.Update(&reacted); err != nil {
+		log.Error("Failed to store reaction", "message", orig.ID, "err", err)
+		h.sendToSession(session, errorMessage("Cannot react: the reaction could not be saved"))
+		return
+	}
+
+	event := NewMessage(MessageTypeReaction, reacted.ChannelID, session.UserID, session.Username(), emoji)
+	event.Ref = reacted.ID
+	event.Reactions = reacted.Reactions
+	h.deliver(reacted.ChannelID, event)
+}
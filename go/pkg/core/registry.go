@@ -0,0 +1,162 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNickTaken   = errors.New("nickname is registered to another key")
+	ErrNickInvalid = errors.New("nickname must be 1-24 letters, digits, '-' or '_'")
+)
+
+type Registration struct {
+	Nick         string    `json:"nick"`
+	Fingerprint  string    `json:"fingerprint"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Registry binds nicknames to the SSH key fingerprint that first registered
+// them. An empty path keeps the registry in memory only.
+type Registry struct {
+	path  string
+	nicks map[string]*Registration
+	mu    sync.RWMutex
+}
+
+func OpenRegistry(path string) (*Registry, error) {
+	r := &Registry{
+		path:  path,
+		nicks: make(map[string]*Registration),
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	var registrations []*Registration
+	if err := readJSONFile(path, &registrations); err != nil {
+		return nil, fmt.Errorf("load registry: %w", err)
+	}
+	for _, reg := range registrations {
+		r.nicks[nickKey(reg.Nick)] = reg
+	}
+	return r, nil
+}
+
+func (r *Registry) Lookup(nick string) (*Registration, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	reg, ok := r.nicks[nickKey(nick)]
+	return reg, ok
+}
+
+func (r *Registry) NicksFor(fingerprint string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var nicks []string
+	for _, reg := range r.nicks {
+		if reg.Fingerprint == fingerprint {
+			nicks = append(nicks, reg.Nick)
+		}
+	}
+	return nicks
+}
+
+// Allowed reports whether fingerprint may use nick, i.e. the nick is either
+// unregistered or registered to that same fingerprint.
+func (r *Registry) Allowed(nick, fingerprint string) bool {
+	reg, ok := r.Lookup(nick)
+	return !ok || reg.Fingerprint == fingerprint
+}
+
+func (r *Registry) Register(nick, fingerprint string) error {
+	if !ValidNick(nick) {
+		return ErrNickInvalid
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if reg, ok := r.nicks[nickKey(nick)]; ok {
+		if reg.Fingerprint != fingerprint {
+			return ErrNickTaken
+		}
+		return nil
+	}
+
+	r.nicks[nickKey(nick)] = &Registration{
+		Nick:         nick,
+		Fingerprint:  fingerprint,
+		RegisteredAt: time.Now(),
+	}
+	return r.save()
+}
+
+func (r *Registry) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	registrations := make([]*Registration, 0, len(r.nicks))
+	for _, reg := range r.nicks {
+		registrations = append(registrations, reg)
+	}
+	return writeJSONFile(r.path, registrations)
+}
+
+const maxNickLength = 24
+
+func ValidNick(nick string) bool {
+	if len(nick) == 0 || len(nick) > maxNickLength {
+		return false
+	}
+	for _, c := range nick {
+		if !isNickChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNickChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+func nickKey(nick string) string {
+	return strings.ToLower(nick)
+}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces path atomically so a crash never leaves a torn file.
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
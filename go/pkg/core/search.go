@@ -0,0 +1,310 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+)
+
+const searchLimit = 50
+
+// Index is an inverted index from words to the messages that contain them.
+type Index struct {
+	docs     map[string]*Message
+	postings map[string]map[string]struct{}
+	mu       sync.RWMutex
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*Message),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Add indexes msg, replacing an earlier version of it. Deleted messages and
+// anything that is not chat are left out.
+func (x *Index) Add(msg *Message) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(msg.ID)
+	if msg.Deleted || msg.Type != MessageTypeChat && msg.Type != MessageTypePrivate {
+		return
+	}
+
+	x.docs[msg.ID] = msg
+	for _, token := range tokenize(msg.Text) {
+		ids, ok := x.postings[token]
+		if !ok {
+			ids = make(map[string]struct{})
+			x.postings[token] = ids
+		}
+		ids[msg.ID] = struct{}{}
+	}
+}
+
+// Remove drops the message with the given ID from the index.
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	x.removeLocked(id)
+	x.mu.Unlock()
+}
+
+func (x *Index) removeLocked(id string) {
+	old, ok := x.docs[id]
+	if !ok {
+		return
+	}
+	delete(x.docs, id)
+	for _, token := range tokenize(old.Text) {
+		if ids, ok := x.postings[token]; ok {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(x.postings, token)
+			}
+		}
+	}
+}
+
+// Search returns messages containing every term that pass keep, newest
+// first.
+func (x *Index) Search(terms []string, keep func(*Message) bool, limit int) []*Message {
+	var candidates []*Message
+
+	x.mu.RLock()
+	if len(terms) == 0 {
+		for _, msg := range x.docs {
+			candidates = append(candidates, msg)
+		}
+	} else {
+		// Walk the rarest term's postings and check the others.
+		sort.Slice(terms, func(i, j int) bool {
+			return len(x.postings[terms[i]]) < len(x.postings[terms[j]])
+		})
+	next:
+		for id := range x.postings[terms[0]] {
+			for _, term := range terms[1:] {
+				if _, ok := x.postings[term][id]; !ok {
+					continue next
+				}
+			}
+			candidates = append(candidates, x.docs[id])
+		}
+	}
+	x.mu.RUnlock()
+
+	// keep may take other locks, so it runs without holding the index.
+	var matches []*Message
+	for _, msg := range candidates {
+		if keep(msg) {
+			matches = append(matches, msg)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Timestamp.After(matches[j].Timestamp)
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// indexedStore keeps an Index in step with everything written to a Store.
+type indexedStore struct {
+	Store
+	index *Index
+}
+
+func (s *indexedStore) Append(msg *Message) error {
+	if err := s.Store.Append(msg); err != nil {
+		return err
+	}
+	s.index.Add(msg)
+	return nil
+}
+
+func (s *indexedStore) Update(msg *Message) error {
+	if err := s.Store.Update(msg); err != nil {
+		return err
+	}
+	s.index.Add(msg)
+	return nil
+}
+
+func (s *indexedStore) Purge(channelID string) error {
+	history, err := s.Store.RangeChannel(channelID, time.Time{}, 0)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.Purge(channelID); err != nil {
+		return err
+	}
+	for _, msg := range history {
+		s.index.Remove(msg.ID)
+	}
+	return nil
+}
+
+// buildIndex indexes the existing history and routes later writes through
+// the index.
+func (h *Hub) buildIndex() {
+	h.index = NewIndex()
+	err := h.store.RangeTime(time.Time{}, time.Time{}, func(msg *Message) bool {
+		h.index.Add(msg)
+		return true
+	})
+	if err != nil {
+		log.Error("Failed to index history", "err", err)
+	}
+	// Messages a capped store lets go of can no longer be shown, so they
+	// must not be found either.
+	if s, ok := h.store.(interface{ OnEvict(func(*Message)) }); ok {
+		s.OnEvict(func(msg *Message) { h.index.Remove(msg.ID) })
+	}
+	h.store = &indexedStore{Store: h.store, index: h.index}
+}
+
+// SearchQuery is a parsed /search query. Terms must all appear in a message;
+// the rest narrow down where and when it was sent.
+type SearchQuery struct {
+	Terms   []string
+	Channel string
+	From    string
+	Before  time.Time
+	After   time.Time
+}
+
+var errEmptySearch = errors.New("search for some words, or narrow down with in:, from:, before: or after:")
+
+func ParseSearchQuery(query string) (SearchQuery, error) {
+	var q SearchQuery
+	for _, word := range strings.Fields(query) {
+		key, value, ok := strings.Cut(word, ":")
+		if ok && value != "" {
+			switch strings.ToLower(key) {
+			case "in":
+				q.Channel = strings.TrimPrefix(value, "#")
+				continue
+			case "from":
+				q.From = strings.TrimPrefix(value, "@")
+				continue
+			case "before", "after":
+				t, err := parseSearchDate(value)
+				if err != nil {
+					return q, fmt.Errorf("invalid %s date %q, use YYYY-MM-DD", key, value)
+				}
+				if strings.EqualFold(key, "before") {
+					q.Before = t
+				} else {
+					q.After = t.AddDate(0, 0, 1)
+				}
+				continue
+			}
+		}
+		q.Terms = append(q.Terms, tokenize(word)...)
+	}
+
+	if len(q.Terms) == 0 && q.Channel == "" && q.From == "" && q.Before.IsZero() && q.After.IsZero() {
+		return q, errEmptySearch
+	}
+	return q, nil
+}
+
+func parseSearchDate(s string) (time.Time, error) {
+	switch strings.ToLower(s) {
+	case "today":
+		y, m, d := time.Now().Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local), nil
+	case "yesterday":
+		y, m, d := time.Now().AddDate(0, 0, -1).Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.Local), nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// canRead reports whether userID may see the messages of channelID. Private
+// channels are only readable by their members.
+func (h *Hub) canRead(userID, channelID string) bool {
+	if IsDirect(channelID) {
+		conv := h.findConversation(channelID)
+		return conv != nil && conv.Includes(userID)
+	}
+	channel := h.findChannel(channelID)
+	return channel != nil && (!channel.Modes().Private() || channel.Allowed(userID))
+}
+
+// Search runs a /search query for userID over every channel and conversation
+// they can read.
+func (h *Hub) Search(userID, query string) ([]*Message, error) {
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	fromID := ""
+	if q.From != "" {
+		if id, _, ok := h.resolvePeer(userID, q.From); ok {
+			fromID = id
+		}
+	}
+
+	keep := func(msg *Message) bool {
+		if q.Channel != "" && msg.ChannelID != q.Channel {
+			return false
+		}
+		if q.From != "" && msg.UserID != fromID && !strings.EqualFold(msg.Username, q.From) {
+			return false
+		}
+		if !q.Before.IsZero() && !msg.Timestamp.Before(q.Before) {
+			return false
+		}
+		if !q.After.IsZero() && msg.Timestamp.Before(q.After) {
+			return false
+		}
+		return h.canRead(userID, msg.ChannelID)
+	}
+	return h.index.Search(q.Terms, keep, searchLimit), nil
+}
+
+// History returns up to limit messages of channelID sent before the given
+// time, oldest first, if userID may read them.
+func (h *Hub) History(userID, channelID string, before time.Time, limit int) ([]*Message, error) {
+	if !h.canRead(userID, channelID) {
+		return nil, ErrNotPermitted
+	}
+	return h.store.RangeChannel(channelID, before, limit)
+}
+
+// HistoryFrom returns up to limit messages of channelID sent at or after the
+// given time, oldest first, if userID may read them.
+func (h *Hub) HistoryFrom(userID, channelID string, from time.Time, limit int) ([]*Message, error) {
+	if !h.canRead(userID, channelID) {
+		return nil, ErrNotPermitted
+	}
+	return h.store.RangeChannelFrom(channelID, from, limit)
+}
+
+// cmdSearch checks the query and asks the client to show its results.
+func (h *Hub) cmdSearch(session *Session, query string) {
+	if _, err := ParseSearchQuery(query); err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot search: %v", err)))
+		return
+	}
+
+	show := NewMessage(MessageTypeSearch, "", "system", "System", query)
+	h.sendToSession(session, show)
+}
@@ -1,40 +1,68 @@
 package core
 
 import (
+	"strings"
+	"sync"
+
 	"github.com/charmbracelet/ssh"
+	"github.com/google/uuid"
 )
 
 type Session struct {
 	ID             string
 	UserID         string
-	Username       string
 	CurrentChannel string
+	HasKey         bool
+	requestedName  string
+	user           *User
 	inbox          chan *Message
 	outbox         chan *Message
 	commands       chan Command
 	done           chan struct{}
+	closeOnce      sync.Once
+	quitReason     string
 }
 
 func NewSession(sshSession ssh.Session) *Session {
 	fingerprint := sshSession.Context().Value("fingerprint").(string)
 
-	username := sshSession.User()
+	username := sanitizeNick(sshSession.User())
 	if username == "" {
-		username = "anonymous-" + fingerprint[:8]
+		username = "anonymous-" + shortFingerprint(fingerprint)
 	}
 
 	return &Session{
-		ID:       fingerprint,
-		UserID:   fingerprint,
-		Username: username,
-		inbox:    make(chan *Message, 64),
-		outbox:   make(chan *Message, 64),
-		commands: make(chan Command, 16),
-		done:     make(chan struct{}),
+		ID:            uuid.NewString(),
+		UserID:        fingerprint,
+		HasKey:        sshSession.PublicKey() != nil,
+		requestedName: username,
+		inbox:         make(chan *Message, 64),
+		outbox:        make(chan *Message, 64),
+		commands:      make(chan Command, 16),
+		done:          make(chan struct{}),
+	}
+}
+
+// Username is the nickname of the owning user, or the name requested over
+// SSH until the hub has registered the session.
+func (s *Session) Username() string {
+	if s.user != nil {
+		return s.user.Username()
 	}
+	return s.requestedName
+}
+
+func (s *Session) User() *User {
+	return s.user
 }
 
 func (s *Session) SendMessage(text string) {
+	s.SendReply("", text)
+}
+
+// SendReply posts text in the thread of the message parentID, or in the main
+// channel flow when parentID is empty.
+func (s *Session) SendReply(parentID, text string) {
 	if s.CurrentChannel == "" {
 		return
 	}
@@ -43,9 +71,10 @@ func (s *Session) SendMessage(text string) {
 		MessageTypeChat,
 		s.CurrentChannel,
 		s.UserID,
-		s.Username,
+		s.Username(),
 		text,
 	)
+	msg.ParentID = parentID
 
 	select {
 	case s.inbox <- msg:
@@ -54,6 +83,21 @@ func (s *Session) SendMessage(text string) {
 	}
 }
 
+// SendTyping tells the others in the current channel that the user is
+// typing.
+func (s *Session) SendTyping() {
+	if s.CurrentChannel == "" {
+		return
+	}
+
+	msg := NewMessage(MessageTypeTyping, s.CurrentChannel, s.UserID, s.Username(), "")
+	select {
+	case s.inbox <- msg:
+	default:
+		// Typing is best effort, drop it rather than wait.
+	}
+}
+
 func (s *Session) SendCommand(cmd Command) {
 	select {
 	case s.commands <- cmd:
@@ -78,10 +122,43 @@ func (s *Session) inboundMessages() <-chan *Message {
 }
 
 func (s *Session) Close() {
-	select {
-	case <-s.done:
-		return
-	default:
+	s.Quit("")
+}
+
+// Quit closes the session. reason is shown to others if this was the user's
+// last connection; only the first reason given sticks.
+func (s *Session) Quit(reason string) {
+	s.closeOnce.Do(func() {
+		s.quitReason = reason
 		close(s.done)
+	})
+}
+
+// QuitReason is the reason given to Quit. It is only meaningful once the
+// session is closed.
+func (s *Session) QuitReason() string {
+	return s.quitReason
+}
+
+func sanitizeNick(name string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if b.Len() >= 24 {
+			break
+		}
+		if ValidNick(string(c)) {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func shortFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimPrefix(fingerprint, "SHA256:")
+	if len(fingerprint) > 8 {
+		return fingerprint[:8]
 	}
+	return fingerprint
 }
@@ -0,0 +1,431 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+type Store interface {
+	Append(msg *Message) error
+	// Update replaces the stored message with the same ID and channel.
+	Update(msg *Message) error
+	RangeChannel(channelID string, before time.Time, limit int) ([]*Message, error)
+	// RangeChannelFrom returns up to limit messages of channelID sent at or
+	// after from, oldest first.
+	RangeChannelFrom(channelID string, from time.Time, limit int) ([]*Message, error)
+	RangeTime(from, to time.Time, fn func(*Message) bool) error
+	// Lookup returns the message of channelID with the given ID or short ID.
+	Lookup(channelID, id string) (*Message, error)
+	// Replies returns the replies to parentID in channelID, oldest first.
+	Replies(channelID, parentID string) ([]*Message, error)
+	// Purge drops every message of channelID.
+	Purge(channelID string) error
+	Close() error
+}
+
+type MemoryStore struct {
+	channels map[string]*history
+	limit    int
+	evicted  func(*Message)
+	mu       sync.RWMutex
+}
+
+// history is one channel's messages, oldest first, with an index from message
+// ID to position, from short ID to ID and from thread root to the IDs of its
+// replies. trimmed counts the messages dropped from the front to stay within
+// the limit, so positions stay valid as the history is capped.
+type history struct {
+	messages []*Message
+	trimmed  int
+	index    map[string]int
+	short    map[string]string
+	replies  map[string][]string
+}
+
+func NewMemoryStore(limit int) *MemoryStore {
+	return &MemoryStore{
+		channels: make(map[string]*history),
+		limit:    limit,
+	}
+}
+
+func (s *MemoryStore) Append(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.channels[msg.ChannelID]
+	if !ok {
+		h = &history{
+			index:   make(map[string]int),
+			short:   make(map[string]string),
+			replies: make(map[string][]string),
+		}
+		s.channels[msg.ChannelID] = h
+	}
+
+	h.index[msg.ID] = h.trimmed + len(h.messages)
+	h.short[msg.ShortID()] = msg.ID
+	if msg.ParentID != "" {
+		h.replies[msg.ParentID] = append(h.replies[msg.ParentID], msg.ID)
+	}
+	h.messages = append(h.messages, msg)
+	if s.limit > 0 && len(h.messages) > s.limit {
+		drop := len(h.messages) - s.limit
+		for _, old := range h.messages[:drop] {
+			delete(h.index, old.ID)
+			if h.short[old.ShortID()] == old.ID {
+				delete(h.short, old.ShortID())
+			}
+			delete(h.replies, old.ID)
+			if s.evicted != nil {
+				s.evicted(old)
+			}
+		}
+		h.messages = h.messages[drop:]
+		h.trimmed += drop
+	}
+	return nil
+}
+
+// OnEvict sets fn to be called with every message dropped to stay within
+// the limit.
+func (s *MemoryStore) OnEvict(fn func(*Message)) {
+	s.mu.Lock()
+	s.evicted = fn
+	s.mu.Unlock()
+}
+
+func (s *MemoryStore) Update(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.channels[msg.ChannelID]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	i, ok := h.index[msg.ID]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	h.messages[i-h.trimmed] = msg
+	return nil
+}
+
+// Lookup finds a message by ID, or by short ID, in which case the newest
+// message with that short ID wins.
+func (s *MemoryStore) Lookup(channelID, id string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.channels[channelID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if full, ok := h.short[id]; ok {
+		id = full
+	}
+	i, ok := h.index[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return h.messages[i-h.trimmed], nil
+}
+
+// Replies skips replies that were dropped to stay within the limit.
+func (s *MemoryStore) Replies(channelID, parentID string) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.channels[channelID]
+	if !ok {
+		return nil, nil
+	}
+	var replies []*Message
+	for _, id := range h.replies[parentID] {
+		if i, ok := h.index[id]; ok {
+			replies = append(replies, h.messages[i-h.trimmed])
+		}
+	}
+	return replies, nil
+}
+
+func (s *MemoryStore) RangeChannel(channelID string, before time.Time, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var history []*Message
+	if h, ok := s.channels[channelID]; ok {
+		history = h.messages
+	}
+
+	end := len(history)
+	if !before.IsZero() {
+		end = sort.Search(len(history), func(i int) bool {
+			return !history[i].Timestamp.Before(before)
+		})
+	}
+
+	start := 0
+	if limit > 0 && end > limit {
+		start = end - limit
+	}
+
+	result := make([]*Message, end-start)
+	copy(result, history[start:end])
+	return result, nil
+}
+
+func (s *MemoryStore) RangeChannelFrom(channelID string, from time.Time, limit int) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var history []*Message
+	if h, ok := s.channels[channelID]; ok {
+		history = h.messages
+	}
+
+	start := sort.Search(len(history), func(i int) bool {
+		return !history[i].Timestamp.Before(from)
+	})
+
+	end := len(history)
+	if limit > 0 && end-start > limit {
+		end = start + limit
+	}
+
+	result := make([]*Message, end-start)
+	copy(result, history[start:end])
+	return result, nil
+}
+
+func (s *MemoryStore) RangeTime(from, to time.Time, fn func(*Message) bool) error {
+	s.mu.RLock()
+	var matched []*Message
+	for _, h := range s.channels {
+		for _, msg := range h.messages {
+			if !from.IsZero() && msg.Timestamp.Before(from) {
+				continue
+			}
+			if !to.IsZero() && !msg.Timestamp.Before(to) {
+				continue
+			}
+			matched = append(matched, msg)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.Before(matched[j].Timestamp)
+	})
+
+	for _, msg := range matched {
+		if !fn(msg) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Purge(channelID string) error {
+	s.mu.Lock()
+	delete(s.channels, channelID)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore is an append-only log of JSON encoded messages, one per line.
+// The whole log is replayed into memory on open and served from there. Edits
+// append the new version; deletions rewrite the log so the deleted text is
+// gone from disk.
+type FileStore struct {
+	*MemoryStore
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(0),
+		path:        path,
+		file:        file,
+	}
+
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) replay() error {
+	scanner := bufio.NewScanner(s.file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line, superseded := 0, 0
+	for scanner.Scan() {
+		line++
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return fmt.Errorf("replay store line %d: %w", line, err)
+		}
+		// A message that is already known is a later edit of it.
+		err := s.MemoryStore.Update(&msg)
+		switch {
+		case errors.Is(err, ErrMessageNotFound):
+			s.MemoryStore.Append(&msg)
+		case err == nil:
+			superseded++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("replay store: %w", err)
+	}
+
+	if superseded > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.compactLocked("")
+	}
+	return nil
+}
+
+func (s *FileStore) Append(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	return s.MemoryStore.Append(msg)
+}
+
+// Update appends the new version of msg to the log; replay keeps the last.
+// A deletion also rewrites the log without the deleted text.
+func (s *FileStore) Update(msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.MemoryStore.Update(msg); err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	if msg.Deleted {
+		// The tombstone is already logged, so a failed rewrite only leaves
+		// the old text on disk until the next one.
+		if err := s.compactLocked(""); err != nil {
+			log.Error("Failed to compact message store", "path", s.path, "err", err)
+		}
+	}
+	return nil
+}
+
+// Purge rewrites the log without the messages of channelID.
+func (s *FileStore) Purge(channelID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.MemoryStore.mu.RLock()
+	_, ok := s.MemoryStore.channels[channelID]
+	s.MemoryStore.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if err := s.compactLocked(channelID); err != nil {
+		return err
+	}
+	return s.MemoryStore.Purge(channelID)
+}
+
+// compactLocked rewrites the log with only the current version of every
+// message, leaving out the channel skip if set. Callers must hold s.mu.
+func (s *FileStore) compactLocked(skip string) error {
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("compact store: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	s.MemoryStore.mu.RLock()
+	for channelID, h := range s.MemoryStore.channels {
+		if channelID == skip {
+			continue
+		}
+		for _, msg := range h.messages {
+			if err == nil {
+				err = enc.Encode(msg)
+			}
+		}
+	}
+	s.MemoryStore.mu.RUnlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("compact store: %w", err)
+	}
+
+	reopened, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("reopen store: %w", err)
+	}
+	s.file.Close()
+	s.file = reopened
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/charmbracelet/log"
+)
+
+// Thread returns the message rootID in channelID and its replies, oldest
+// first.
+func (h *Hub) Thread(channelID, rootID string) (*Message, []*Message) {
+	root, err := h.store.Lookup(channelID, rootID)
+	if err != nil {
+		if !errors.Is(err, ErrMessageNotFound) {
+			log.Error("Failed to load thread", "channel", channelID, "err", err)
+		}
+		return nil, nil
+	}
+	replies, err := h.store.Replies(channelID, root.ID)
+	if err != nil {
+		log.Error("Failed to load thread", "channel", channelID, "err", err)
+	}
+	return root, replies
+}
+
+// attachReply checks that a reply's parent exists and counts the reply on
+// it. Replies to replies join the parent's thread, so threads stay one level
+// deep. Messages that are not replies pass through.
+func (h *Hub) attachReply(session *Session, msg *Message) bool {
+	if msg.ParentID == "" {
+		return true
+	}
+
+	h.updateMu.Lock()
+	defer h.updateMu.Unlock()
+
+	root, err := h.store.Lookup(msg.ChannelID, msg.ParentID)
+	if err == nil && root.ParentID != "" {
+		root, err = h.store.Lookup(msg.ChannelID, root.ParentID)
+	}
+	if err != nil || root.Deleted {
+		h.sendToSession(session, errorMessage("The message you are replying to is gone"))
+		return false
+	}
+
+	counted := *root
+	counted.Replies++
+	if err := h.store.Update(&counted); err != nil {
+		log.Error("Failed to count reply", "message", root.ID, "err", err)
+	}
+	msg.ParentID = root.ID
+	return true
+}
+
+func (h *Hub) cmdReply(session *Session, ref, text string) {
+	parent, err := h.findMessage(session, ref)
+	if err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot reply: %v", err)))
+		return
+	}
+
+	msg := NewMessage(MessageTypeChat, parent.ChannelID, session.UserID, session.Username(), text)
+	msg.ParentID = parent.ID
+	h.broadcastToChannel(session, msg)
+}
+
+// cmdThread asks the client to show the thread a message belongs to.
+func (h *Hub) cmdThread(session *Session, ref string) {
+	msg, err := h.findMessage(session, ref)
+	if err != nil {
+		h.sendToSession(session, errorMessage(fmt.Sprintf("Cannot open thread: %v", err)))
+		return
+	}
+
+	rootID := msg.ID
+	if msg.ParentID != "" {
+		rootID = msg.ParentID
+	}
+	open := NewMessage(MessageTypeThread, msg.ChannelID, "system", "System", "")
+	open.Ref = rootID
+	h.sendToSession(session, open)
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// User is a single identity (an SSH key fingerprint) that may be connected
+// through any number of sessions at once.
+type User struct {
+	ID       string
+	HasKey   bool
+	username string
+	sessions map[string]*Session
+	channels []string
+
+	// Presence: an away message set with /away, the last input seen from
+	// any connection, and the presence others were last told about.
+	isAway      bool
+	away        string
+	awayReplied map[string]bool
+	lastActive  time.Time
+	shown       Presence
+
+	mu sync.RWMutex
+}
+
+func NewUser(id, username string, hasKey bool) *User {
+	return &User{
+		ID:         id,
+		HasKey:     hasKey,
+		username:   username,
+		sessions:   make(map[string]*Session),
+		lastActive: time.Now(),
+		shown:      PresenceOnline,
+	}
+}
+
+func (u *User) Username() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.username
+}
+
+func (u *User) SetUsername(username string) {
+	u.mu.Lock()
+	u.username = username
+	u.mu.Unlock()
+}
+
+func (u *User) AddSession(session *Session) {
+	u.mu.Lock()
+	u.sessions[session.ID] = session
+	u.mu.Unlock()
+	session.user = u
+}
+
+// RemoveSession detaches a session and returns how many remain.
+func (u *User) RemoveSession(sessionID string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.sessions, sessionID)
+	return len(u.sessions)
+}
+
+func (u *User) Sessions() []*Session {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(u.sessions))
+	for _, s := range u.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (u *User) Online() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return len(u.sessions) > 0
+}
+
+func (u *User) ConnectionCount() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return len(u.sessions)
+}
+
+func (u *User) Deliver(msg *Message) {
+	for _, s := range u.Sessions() {
+		s.EnqueueOutbound(msg)
+	}
+}
+
+// Channels lists the channels the user is a member of, in the order they
+// were joined.
+func (u *User) Channels() []string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return append([]string(nil), u.channels...)
+}
+
+func (u *User) InChannel(name string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return slices.Contains(u.channels, name)
+}
+
+func (u *User) addChannel(name string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !slices.Contains(u.channels, name) {
+		u.channels = append(u.channels, name)
+	}
+}
+
+func (u *User) removeChannel(name string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.channels = slices.DeleteFunc(u.channels, func(c string) bool {
+		return c == name
+	})
+}
+
+// Refresh tells every connection to resync its view of the user's state.
+func (u *User) Refresh() {
+	u.Deliver(refreshMessage())
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	"github.com/frikkfelix/sshchat/go/pkg/config"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+	"github.com/google/uuid"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	offeredKeyContextKey = "offered-key"
+	// bannedContextKey marks a connection that offered a globally banned
+	// key, so it cannot get in as a guest or redeem an invite instead.
+	bannedContextKey = "banned"
+)
+
+// AuthPolicy decides who may connect. PublicKey is consulted for every key a
+// client offers; KeyboardInteractive runs for clients without an accepted
+// key and returns the identity to use for the connection.
+type AuthPolicy interface {
+	PublicKey(ctx ssh.Context, key ssh.PublicKey) bool
+	KeyboardInteractive(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) (string, bool)
+}
+
+func NewAuthPolicy(cfg *config.Config, invites *core.Invites) (AuthPolicy, error) {
+	switch cfg.Auth.Mode {
+	case config.AuthOpen:
+		return &OpenPolicy{Guests: true}, nil
+	case config.AuthPublicKey:
+		return &OpenPolicy{}, nil
+	case config.AuthAllowlist:
+		return NewAllowlistPolicy(cfg.Auth.AuthorizedKeys, cfg.Admins, invites)
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Auth.Mode)
+	}
+}
+
+// OpenPolicy admits every key, and with Guests also clients without one under
+// a random identity.
+type OpenPolicy struct {
+	Guests bool
+}
+
+func (p *OpenPolicy) PublicKey(ssh.Context, ssh.PublicKey) bool {
+	return true
+}
+
+func (p *OpenPolicy) KeyboardInteractive(ssh.Context, gossh.KeyboardInteractiveChallenge) (string, bool) {
+	if !p.Guests {
+		return "", false
+	}
+	return uuid.NewString(), true
+}
+
+// AllowlistPolicy admits keys listed in an authorized_keys file, which is
+// re-read whenever it changes on disk. Admins are always admitted. A client
+// whose key is not listed can redeem an invite code over keyboard-interactive
+// to have that key added to the file.
+type AllowlistPolicy struct {
+	path    string
+	admins  map[string]bool
+	invites *core.Invites
+
+	keys    map[string]bool
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+func NewAllowlistPolicy(path string, admins []string, invites *core.Invites) (*AllowlistPolicy, error) {
+	p := &AllowlistPolicy{
+		path:    path,
+		admins:  make(map[string]bool),
+		invites: invites,
+		keys:    make(map[string]bool),
+	}
+	for _, fp := range admins {
+		p.admins[fp] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *AllowlistPolicy) PublicKey(ctx ssh.Context, key ssh.PublicKey) bool {
+	fingerprint := gossh.FingerprintSHA256(key)
+	if p.admins[fingerprint] || p.allowed(fingerprint) {
+		return true
+	}
+
+	// Remember the first key the client offered so a redeemed invite can
+	// enroll it.
+	if ctx.Value(offeredKeyContextKey) == nil {
+		ctx.SetValue(offeredKeyContextKey, key)
+	}
+	return false
+}
+
+func (p *AllowlistPolicy) KeyboardInteractive(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) (string, bool) {
+	if p.invites == nil {
+		return "", false
+	}
+
+	key, _ := ctx.Value(offeredKeyContextKey).(ssh.PublicKey)
+	if key == nil {
+		notify(challenger, "This server requires an SSH key. Create one with ssh-keygen and reconnect.")
+		return "", false
+	}
+
+	answers, err := challenger("", "Your key is not on this server's allowlist.", []string{"Invite code: "}, []bool{true})
+	if err != nil || len(answers) != 1 || !p.invites.Redeem(answers[0]) {
+		return "", false
+	}
+
+	if err := p.enroll(key); err != nil {
+		log.Error("Failed to enroll key", "path", p.path, "err", err)
+		return "", false
+	}
+	log.Info("Enrolled key with invite", "fingerprint", gossh.FingerprintSHA256(key))
+
+	// An offered key has not necessarily been proven to belong to this
+	// client, so the connection itself is not logged in as that key. The
+	// client has to reconnect and authenticate with it.
+	notify(challenger, fmt.Sprintf("Invite accepted, key %s is now enrolled. Reconnect to log in.",
+		gossh.FingerprintSHA256(key)))
+	return "", false
+}
+
+// notify shows text to the client. OpenSSH only prints the instruction of a
+// challenge that has at least one prompt, so it asks for a keypress.
+func notify(challenger gossh.KeyboardInteractiveChallenge, text string) {
+	_, _ = challenger("", text, []string{"Press Enter to disconnect "}, []bool{true})
+}
+
+func (p *AllowlistPolicy) allowed(fingerprint string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.reloadLocked(); err != nil {
+		log.Error("Failed to reload authorized keys", "path", p.path, "err", err)
+	}
+	return p.keys[fingerprint]
+}
+
+// reloadLocked re-reads the authorized_keys file if it changed since the last
+// read. Callers must hold p.mu.
+func (p *AllowlistPolicy) reloadLocked() error {
+	info, err := os.Stat(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		p.keys = make(map[string]bool)
+		p.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]bool)
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := gossh.ParseAuthorizedKey(line)
+		if err != nil {
+			log.Warn("Skipping invalid authorized key", "path", p.path, "line", i+1, "err", err)
+			continue
+		}
+		keys[gossh.FingerprintSHA256(key)] = true
+	}
+
+	p.keys = keys
+	p.modTime = info.ModTime()
+	log.Info("Loaded authorized keys", "path", p.path, "keys", len(keys))
+	return nil
+}
+
+func (p *AllowlistPolicy) enroll(key ssh.PublicKey) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	line := bytes.TrimSpace(gossh.MarshalAuthorizedKey(key))
+	line = fmt.Appendf(line, " enrolled-by-invite-%s\n", time.Now().Format("2006-01-02"))
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	p.keys[gossh.FingerprintSHA256(key)] = true
+	return nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/keygen"
+	"github.com/charmbracelet/log"
+	"github.com/charmbracelet/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// hostKeyType picks the key algorithm from the file name the way OpenSSH
+// names host keys (ssh_host_rsa_key, ssh_host_ecdsa_key, ...). Anything
+// else is an ed25519 key.
+func hostKeyType(path string) keygen.KeyType {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.Contains(name, "rsa"):
+		return keygen.RSA
+	case strings.Contains(name, "ecdsa"):
+		return keygen.ECDSA
+	default:
+		return keygen.Ed25519
+	}
+}
+
+// loadHostKeys loads every host key in paths, generating and saving any that
+// do not exist yet. Without paths a throwaway ed25519 key is generated.
+func loadHostKeys(paths []string) ([]gossh.Signer, error) {
+	if len(paths) == 0 {
+		pair, err := keygen.New("", keygen.WithKeyType(keygen.Ed25519))
+		if err != nil {
+			return nil, fmt.Errorf("generate host key: %w", err)
+		}
+		log.Warn("No host key configured, using a throwaway key that changes on every restart")
+		return []gossh.Signer{pair.Signer()}, nil
+	}
+
+	signers := make([]gossh.Signer, 0, len(paths))
+	for _, path := range paths {
+		pair, err := keygen.New(path, keygen.WithKeyType(hostKeyType(path)), keygen.WithWrite())
+		if err != nil {
+			return nil, fmt.Errorf("load host key %s: %w", path, err)
+		}
+		signers = append(signers, pair.Signer())
+	}
+	return signers, nil
+}
+
+func withHostKeys(signers []gossh.Signer) ssh.Option {
+	return func(s *ssh.Server) error {
+		for _, signer := range signers {
+			s.AddHostKey(signer)
+		}
+		return nil
+	}
+}
+
+func logHostKeys(signers []gossh.Signer) {
+	for _, signer := range signers {
+		key := signer.PublicKey()
+		log.Info("Host key", "type", key.Type(), "fingerprint", gossh.FingerprintSHA256(key))
+	}
+}
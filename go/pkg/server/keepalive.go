@@ -0,0 +1,48 @@
+package server
+
+import (
+	"time"
+
+	"github.com/charmbracelet/ssh"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// keepAlive pings the client and closes the connection as timed out when no
+// answer arrives within timeout.
+func keepAlive(s ssh.Session, session *core.Session, timeout time.Duration) {
+	conn, ok := s.Context().Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+
+		select {
+		case <-s.Context().Done():
+			return
+		case err := <-replied:
+			if err != nil {
+				return
+			}
+		case <-time.After(timeout):
+			session.Quit(core.QuitTimeout)
+			_ = conn.Close()
+			return
+		}
+	}
+}
@@ -2,7 +2,6 @@ package server
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,59 +12,86 @@ import (
 	"github.com/charmbracelet/wish/activeterm"
 	"github.com/charmbracelet/wish/bubbletea"
 	"github.com/charmbracelet/wish/logging"
+	"github.com/frikkfelix/sshchat/go/pkg/config"
 	"github.com/frikkfelix/sshchat/go/pkg/core"
 	"github.com/frikkfelix/sshchat/go/pkg/tui"
-	"github.com/google/uuid"
+	"github.com/muesli/termenv"
 	gossh "golang.org/x/crypto/ssh"
 	xssh "golang.org/x/crypto/ssh"
 )
 
-const (
-	host = "0.0.0.0"
-	port = "42069"
-)
-
 type Server struct {
 	hub *core.Hub
 	SSH *ssh.Server
 }
 
-func New(hub *core.Hub) (*Server, error) {
-	teaHandler := func(s ssh.Session) (tea.Model, []tea.ProgramOption) {
+func New(hub *core.Hub, cfg *config.Config, policy AuthPolicy) (*Server, error) {
+	programHandler := func(s ssh.Session) *tea.Program {
 		session := core.NewSession(s)
 		hub.RegisterSession(session)
 
 		go func() {
 			<-s.Context().Done()
-			session.Close()
+			session.Quit("Connection closed")
 		}()
+		if cfg.Timeout > 0 {
+			go keepAlive(s, session, cfg.Timeout)
+		}
 
-		model := tui.NewModel(session, hub)
+		// The model writes the bell and clipboard updates itself, so it
+		// shares the program's output rather than writing to s directly.
+		out := tui.NewTerminal(s)
+		model := tui.NewModel(session, hub, out)
 
-		return model, []tea.ProgramOption{
+		opts := append(bubbletea.MakeOptions(s),
+			tea.WithOutput(out),
 			tea.WithAltScreen(),
 			tea.WithMouseCellMotion(),
-		}
+		)
+		return tea.NewProgram(model, opts...)
 	}
-	wishServer, err := wish.NewServer(
-		wish.WithAddress(fmt.Sprintf("%s:%s", host, port)),
+	options := []ssh.Option{
+		wish.WithAddress(cfg.Listen),
 		wish.WithMiddleware(
-			bubbletea.Middleware(teaHandler),
+			bubbletea.MiddlewareWithProgramHandler(programHandler, termenv.Ascii),
 			activeterm.Middleware(),
 			logging.Middleware(),
 		),
 		wish.WithPublicKeyAuth(func(ctx ssh.Context, key ssh.PublicKey) bool {
 			fingerprint := xssh.FingerprintSHA256(key.(gossh.PublicKey))
+			if hub.Banned(fingerprint) {
+				ctx.SetValue(bannedContextKey, true)
+				return false
+			}
+			if !policy.PublicKey(ctx, key) {
+				return false
+			}
 			ctx.SetValue("fingerprint", fingerprint)
 			return true
 		}),
 		wish.WithKeyboardInteractiveAuth(
 			func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
-				ctx.SetValue("fingerprint", uuid.NewString())
+				if ctx.Value(bannedContextKey) != nil {
+					return false
+				}
+				identity, ok := policy.KeyboardInteractive(ctx, challenger)
+				if !ok || hub.Banned(identity) {
+					return false
+				}
+				ctx.SetValue("fingerprint", identity)
 				return true
 			},
 		),
-	)
+	}
+
+	hostKeys, err := loadHostKeys(cfg.HostKeys)
+	if err != nil {
+		return nil, err
+	}
+	logHostKeys(hostKeys)
+	options = append(options, withHostKeys(hostKeys))
+
+	wishServer, err := wish.NewServer(options...)
 
 	return &Server{
 		hub: hub,
@@ -73,10 +99,13 @@ func New(hub *core.Hub) (*Server, error) {
 	}, err
 }
 
-func WaitForShutdown() {
+func WaitForShutdown(requested <-chan struct{}) {
 	shutdownCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownCh, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-shutdownCh
+	select {
+	case <-shutdownCh:
+	case <-requested:
+	}
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
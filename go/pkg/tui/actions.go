@@ -0,0 +1,162 @@
+package tui
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+// handleMessageKey handles the Normal mode keys that move the message cursor
+// and act on the selected message. Without a selection, actions apply to the
+// latest message.
+func (m *Model) handleMessageKey(k tea.KeyMsg) (tea.Cmd, bool) {
+	key := k.String()
+	pending := m.pendingKey
+	m.pendingKey = ""
+	m.notice = ""
+
+	switch key {
+	case "j", "down":
+		m.moveSelection(1)
+		return nil, true
+	case "k", "up":
+		m.moveSelection(-1)
+		return nil, true
+	case "G":
+		m.selectAt(len(m.selectable()) - 1)
+		return nil, true
+	case "g":
+		if pending == "g" {
+			m.selectAt(0)
+		} else {
+			m.pendingKey = "g"
+		}
+		return nil, true
+	case "esc":
+		if m.selected != "" {
+			m.selected = ""
+			m.updateViewport()
+			return nil, true
+		}
+		return nil, false
+	}
+
+	msg := m.target()
+	if msg == nil {
+		return nil, false
+	}
+
+	switch key {
+	case "y":
+		m.notice = "Copied message"
+		return m.copyToClipboard(msg.Text), true
+	case "Y":
+		m.notice = "Copied message ID " + msg.ShortID()
+		return m.copyToClipboard(msg.ID), true
+	case "r":
+		root := msg.ID
+		if msg.ParentID != "" {
+			root = msg.ParentID
+		}
+		m.openThread(m.active, root)
+		return m.input.EnterInsert(), true
+	case "+":
+		m.session.SendCommand(core.Command{Name: "react", Args: []string{msg.ID, "+1"}})
+		return nil, true
+	case "a":
+		return m.input.Prefill(fmt.Sprintf("/react %s ", msg.ShortID())), true
+	case "e":
+		if msg.UserID != m.session.UserID {
+			m.notice = "You can only edit your own messages"
+			return nil, true
+		}
+		return m.input.Prefill(fmt.Sprintf("/edit %s %s", msg.ShortID(), msg.Text)), true
+	case "d":
+		if pending != "d" {
+			m.pendingKey = "d"
+			return nil, true
+		}
+		m.session.SendCommand(core.Command{Name: "delete", Args: []string{msg.ID}})
+		return nil, true
+	}
+	return nil, false
+}
+
+// selectable lists the messages the cursor can land on in the active channel.
+func (m *Model) selectable() []*core.Message {
+	var msgs []*core.Message
+	messages := m.channel(m.active).messages
+	for i := range messages {
+		if msg := &messages[i]; actionable(msg) && msg.ParentID == "" {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func (m *Model) moveSelection(delta int) {
+	msgs := m.selectable()
+	if len(msgs) == 0 {
+		return
+	}
+
+	// The cursor starts from the bottom, where new messages arrive.
+	current := len(msgs)
+	for i, msg := range msgs {
+		if msg.ID == m.selected {
+			current = i
+		}
+	}
+	if m.selected == "" && delta > 0 {
+		return
+	}
+	m.selectAt(max(0, min(current+delta, len(msgs)-1)))
+}
+
+func (m *Model) selectAt(i int) {
+	msgs := m.selectable()
+	if i < 0 || i >= len(msgs) {
+		return
+	}
+	m.selected = msgs[i].ID
+	m.updateViewport()
+}
+
+// target is the message actions apply to.
+func (m *Model) target() *core.Message {
+	if m.selected != "" {
+		for _, msg := range m.selectable() {
+			if msg.ID == m.selected {
+				return msg
+			}
+		}
+	}
+	return m.latestMessage()
+}
+
+// latestMessage is the newest message in the active channel that can be
+// acted on.
+func (m *Model) latestMessage() *core.Message {
+	msgs := m.selectable()
+	if len(msgs) == 0 {
+		return nil
+	}
+	return msgs[len(msgs)-1]
+}
+
+func actionable(msg *core.Message) bool {
+	return (msg.Type == core.MessageTypeChat || msg.Type == core.MessageTypePrivate) && !msg.Deleted
+}
+
+// copyToClipboard sets the client's clipboard with an OSC 52 escape sequence,
+// which terminals honor even over SSH.
+func (m *Model) copyToClipboard(text string) tea.Cmd {
+	return func() tea.Msg {
+		seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+		_, _ = io.WriteString(m.out, seq)
+		return nil
+	}
+}
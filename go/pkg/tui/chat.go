@@ -2,7 +2,10 @@ package tui
 
 import (
 	"fmt"
+	"io"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -13,26 +16,80 @@ import (
 type Model struct {
 	session *core.Session
 	hub     *core.Hub
+	out     io.Writer
+	started time.Time
 
-	messages []core.Message
+	channels map[string]*channelView
+	active   string
 	viewport viewport.Model
 
 	input *InputController
 
-	width  int
-	height int
-	ready  bool
+	sidebar     sidebar
+	showMembers bool
+	thread      *threadView
+	search      *searchView
+	pendingJump *core.Message
+	panes       paneState
+
+	// typing holds who is typing, by channel and user ID.
+	typing map[string]map[string]typist
+
+	// joins is how the user wants join and quit events shown, as of the last
+	// refresh.
+	joins core.JoinDisplay
+
+	// selected is the ID of the message under the Normal mode cursor.
+	selected   string
+	pendingKey string
+	notice     string
+
+	width       int
+	height      int
+	innerWidth  int
+	innerHeight int
+	ready       bool
+}
+
+// channelView is the client side state of one joined channel.
+type channelView struct {
+	messages []core.Message
+	unread   int
+	mentions int
+
+	// loading is set while older history is being fetched, exhausted once
+	// there is none left.
+	loading   bool
+	exhausted bool
+
+	// detached is set when the view stops short of the latest messages,
+	// after jumping to an old one. Live messages of the channel are then
+	// left for scrolling down to load, or kept in pending while that is
+	// under way.
+	detached     bool
+	loadingNewer bool
+	pending      []core.Message
 }
 
 type msgReceived core.Message
 
-func NewModel(session *core.Session, h *core.Hub) *Model {
+// NewModel creates the chat UI for session. out is the client's terminal,
+// used for output outside the rendered view such as the bell. It must be the
+// program's output too, see Terminal.
+func NewModel(session *core.Session, h *core.Hub, out io.Writer) *Model {
 	return &Model{
 		session:  session,
 		hub:      h,
-		messages: []core.Message{},
+		out:      out,
+		started:  time.Now(),
+		channels: make(map[string]*channelView),
+		typing:   make(map[string]map[string]typist),
 		input:    NewInputController(),
 		viewport: viewport.New(80, 20),
+		joins:    h.Preferences(session.UserID).Joins,
+
+		sidebar:     sidebar{visible: true},
+		showMembers: true,
 	}
 }
 
@@ -62,6 +119,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.session.Close()
 			return m, tea.Quit
 		}
+		m.input.Activity(m.session)
 
 		if m.input.Mode() == Command {
 			if cmd, handled := m.input.HandleKey(v, m.session); handled {
@@ -76,6 +134,18 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Batch(cmds...)
 		}
 
+		if m.input.Mode() == Normal {
+			if cmd, handled := m.handleSearchKey(v); handled {
+				return m, cmd
+			}
+			if cmd, handled := m.handlePaneKey(v); handled {
+				return m, cmd
+			}
+			if cmd, handled := m.handleMessageKey(v); handled {
+				return m, tea.Batch(cmd, m.maybeRequestHistory())
+			}
+		}
+
 		if cmd, handled := m.input.HandleKey(v, m.session); handled {
 			if cmd != nil {
 				cmds = append(cmds, cmd)
@@ -89,21 +159,19 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if cmd != nil {
 				cmds = append(cmds, cmd)
 			}
+			if cmd := m.maybeRequestHistory(); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
 			return m, tea.Batch(cmds...)
 		}
 
 		if cmd := m.input.Update(v); cmd != nil {
 			cmds = append(cmds, cmd)
 		}
+		m.input.EmitTyping(m.session)
 
 	case tea.WindowSizeMsg:
 		m.handleResize(v)
-		inputHFrame, inputVFrame := inputBoxStyle.GetFrameSize()
-		textAreaWidth := m.viewport.Width - inputHFrame
-		if textAreaWidth < 0 {
-			textAreaWidth = 0
-		}
-		m.input.OnResize(textAreaWidth, m.viewport.Height, lipgloss.Height(m.statusBar()), inputVFrame)
 
 		var cmd tea.Cmd
 		m.viewport, cmd = m.viewport.Update(v)
@@ -115,10 +183,30 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 	case msgReceived:
-		m.messages = append(m.messages, core.Message(v))
-		m.updateViewport()
+		if cmd := m.receive(core.Message(v)); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
 		cmds = append(cmds, m.listenForMessages())
 
+	case historyLoaded:
+		m.historyLoaded(v)
+
+	case windowLoaded:
+		m.windowLoaded(v)
+
+	case typingExpired:
+		m.pruneTyping()
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		if cmd := m.maybeRequestHistory(); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
 	default:
 		if cmd := m.input.Update(msg); cmd != nil {
 			cmds = append(cmds, cmd)
@@ -141,28 +229,267 @@ func (m *Model) View() string {
 	inputRow := inputBoxStyle.Render(m.input.InlineView())
 	status := m.statusBar()
 
+	body := m.viewport.View()
+	if m.paneWidths() > 0 {
+		var panes []string
+		if m.sidebarShown() {
+			panes = append(panes, m.sidebarView())
+		}
+		panes = append(panes, lipgloss.NewStyle().Width(m.viewport.Width).Render(body))
+		if m.searchShown() {
+			panes = append(panes, m.searchPaneView())
+		} else if m.threadShown() {
+			panes = append(panes, m.threadPaneView())
+		}
+		if m.membersShown() {
+			panes = append(panes, m.membersView())
+		}
+		body = lipgloss.JoinHorizontal(lipgloss.Top, panes...)
+	}
+
 	return appFrameStyle.Render(fmt.Sprintf(
-		"%s\n%s\n%s\n%s",
+		"%s\n%s\n%s\n%s\n%s",
 		m.headerView(),
-		m.viewport.View(),
+		body,
+		m.typingView(),
 		inputRow,
 		status,
 	))
 }
 
-func (m *Model) updateViewport() {
-	var content strings.Builder
-	for _, msg := range m.messages {
-		content.WriteString(m.formatMessage(msg))
-		content.WriteString("\n\n")
+func (m *Model) channel(name string) *channelView {
+	view, ok := m.channels[name]
+	if !ok {
+		view = &channelView{}
+		m.channels[name] = view
+	}
+	return view
+}
+
+// receive files msg under its channel. Messages without a channel, like
+// command replies, belong to whatever channel is on screen. The returned
+// command rings the bell for new messages that mention the user.
+func (m *Model) receive(msg core.Message) tea.Cmd {
+	switch msg.Type {
+	case core.MessageTypeRefresh:
+		if joins := m.hub.Preferences(m.session.UserID).Joins; joins != m.joins {
+			m.joins = joins
+			m.updateViewport()
+		}
+		cmd := m.syncActive()
+		m.refreshPanes()
+		return cmd
+	case core.MessageTypeEdit, core.MessageTypeDelete, core.MessageTypeReaction:
+		m.applyChange(msg)
+		return nil
+	case core.MessageTypeThread:
+		m.openThread(msg.ChannelID, msg.Ref)
+		return nil
+	case core.MessageTypeSearch:
+		m.openSearch(msg.Text)
+		return nil
+	case core.MessageTypeTyping:
+		return m.noteTyping(msg)
+	}
+	m.stopTyping(msg)
+
+	name := msg.ChannelID
+	if name == "" {
+		name = m.active
+	} else if !m.hasEntry(name) {
+		// The first message of a new conversation.
+		m.refreshPanes()
+	}
+
+	view := m.channel(name)
+	switch {
+	case view.detached && msg.ChannelID == name && view.loadingNewer:
+		view.pending = append(view.pending, msg)
+	case view.detached && msg.ChannelID == name:
+		// Loaded with the rest when the user scrolls down to it.
+	default:
+		view.messages = append(view.messages, msg)
+		view.trim()
+	}
+	if msg.ParentID != "" && !msg.Replayed {
+		// Replayed roots already carry their stored reply count.
+		m.countReply(view, msg)
+	}
+
+	var cmd tea.Cmd
+	if m.mentionsMe(msg) && msg.Timestamp.After(m.started) {
+		cmd = m.bell()
+	}
+
+	if name == m.active {
+		m.updateViewport()
+		return cmd
+	}
+	// Replies only show in their thread, so they are not unread in the
+	// channel unless they mention the user.
+	if msg.UserID == m.session.UserID || msg.ParentID != "" && !m.mentionsMe(msg) {
+		return cmd
+	}
+	if msg.Type == core.MessageTypeChat || msg.Type == core.MessageTypePrivate {
+		view.unread++
+		if m.mentionsMe(msg) {
+			view.mentions++
+		}
+	}
+	return cmd
+}
+
+// applyChange updates the message an edit or delete event refers to.
+func (m *Model) applyChange(event core.Message) {
+	if m.thread != nil && m.thread.channel == event.ChannelID {
+		if m.thread.root.ID == event.Ref {
+			applyChange(&m.thread.root, event)
+		}
+		for i := range m.thread.replies {
+			if m.thread.replies[i].ID == event.Ref {
+				applyChange(&m.thread.replies[i], event)
+			}
+		}
+	}
+
+	view, ok := m.channels[event.ChannelID]
+	if !ok {
+		return
+	}
+	for i := len(view.messages) - 1; i >= 0; i-- {
+		if view.messages[i].ID != event.Ref {
+			continue
+		}
+		applyChange(&view.messages[i], event)
+		if event.ChannelID == m.active {
+			m.updateViewport()
+		}
+		return
+	}
+}
+
+func applyChange(msg *core.Message, event core.Message) {
+	switch event.Type {
+	case core.MessageTypeDelete:
+		msg.Text = ""
+		msg.Mentions = nil
+		msg.Reactions = nil
+		msg.Deleted = true
+	case core.MessageTypeReaction:
+		msg.Reactions = event.Reactions
+	default:
+		msg.Text = event.Text
+		msg.Mentions = event.Mentions
+		msg.EditedAt = event.EditedAt
+	}
+}
+
+func (m *Model) mentionsMe(msg core.Message) bool {
+	if msg.UserID == m.session.UserID {
+		return false
+	}
+	switch msg.Type {
+	case core.MessageTypePrivate:
+		return true
+	case core.MessageTypeChat:
+		return msg.Mentioned(m.session.UserID)
+	default:
+		return false
+	}
+}
+
+func (m *Model) bell() tea.Cmd {
+	return func() tea.Msg {
+		_, _ = io.WriteString(m.out, "\a")
+		return nil
 	}
+}
 
-	wrapped := lipgloss.NewStyle().
-		Width(m.viewport.Width).
-		Render(content.String())
+// syncActive follows the session's active channel after the hub changed it.
+func (m *Model) syncActive() tea.Cmd {
+	if m.session.CurrentChannel == m.active {
+		return nil
+	}
+	// Notices that arrived while no channel was on screen, like the MOTD,
+	// move along to the first channel that is.
+	var pending []core.Message
+	if m.active == "" {
+		pending = m.channel("").messages
+		delete(m.channels, "")
+	}
 
-	m.viewport.SetContent(wrapped)
+	m.active = m.session.CurrentChannel
+	m.selected = ""
+	if m.thread != nil && m.thread.channel != m.active {
+		m.closeThread()
+	}
+	view := m.channel(m.active)
+	view.messages = append(view.messages, pending...)
+	view.unread = 0
+	view.mentions = 0
+	m.updateViewport()
 	m.viewport.GotoBottom()
+
+	if m.pendingJump != nil && m.pendingJump.ChannelID == m.active {
+		return m.reveal(*m.pendingJump)
+	}
+	return nil
+}
+
+func (m *Model) updateViewport() {
+	view := m.channel(m.active)
+	follow := m.viewport.AtBottom()
+
+	var blocks []string
+	selectedLine, selectedHeight, lines := -1, 0, 0
+	if marker := view.marker(); marker != "" {
+		blocks = append(blocks, timeStyle.Render(marker))
+		lines += 2
+	}
+	for i := 0; i < len(view.messages); i++ {
+		msg := view.messages[i]
+		if msg.ParentID != "" {
+			continue
+		}
+		if core.IsMembershipEvent(&msg) && m.joins != core.JoinsShow {
+			if m.joins == core.JoinsHide {
+				continue
+			}
+			run := eventRun(view.messages[i:])
+			i += len(run) - 1
+			block := collapseEvents(run, m.viewport.Width)
+			lines += lipgloss.Height(block) + 1
+			blocks = append(blocks, block)
+			continue
+		}
+		block := m.renderMessage(msg, m.viewport.Width)
+
+		if msg.ID == m.selected {
+			selectedLine, selectedHeight = lines, lipgloss.Height(block)
+		}
+		lines += lipgloss.Height(block) + 1
+		blocks = append(blocks, block)
+	}
+	if footer := view.footer(); footer != "" {
+		blocks = append(blocks, timeStyle.Render(footer))
+	}
+
+	m.viewport.SetContent(strings.Join(blocks, "\n\n"))
+	if selectedLine < 0 {
+		// Stay put while the user reads back; follow new messages otherwise.
+		if follow {
+			m.viewport.GotoBottom()
+		}
+		return
+	}
+
+	// Scroll just enough to keep the selected message on screen.
+	switch {
+	case selectedLine < m.viewport.YOffset:
+		m.viewport.SetYOffset(selectedLine)
+	case selectedLine+selectedHeight > m.viewport.YOffset+m.viewport.Height:
+		m.viewport.SetYOffset(selectedLine + selectedHeight - m.viewport.Height)
+	}
 }
 
 func (m *Model) formatMessage(msg core.Message) string {
@@ -177,31 +504,132 @@ func (m *Model) formatMessage(msg core.Message) string {
 	user := UserStyle(key).Render(msg.Username)
 
 	switch msg.Type {
-	case core.MessageTypeSystem, core.MessageTypeJoin, core.MessageTypeLeave:
+	case core.MessageTypeSystem, core.MessageTypeJoin, core.MessageTypeLeave, core.MessageTypeNick:
 		return lipgloss.
 			NewStyle().
 			Render(fmt.Sprintf("%s\n%s", timestamp, msg.Text))
-	default:
-		return fmt.Sprintf("%s %s\n%s", user, timestamp, msg.Text)
 	}
+
+	header := fmt.Sprintf("%s %s", user, timestamp)
+	if msg.Type == core.MessageTypeChat || msg.Type == core.MessageTypePrivate {
+		header += " " + timeStyle.Render(msg.ShortID())
+	}
+	if msg.Deleted {
+		return fmt.Sprintf("%s\n%s", header, tombstoneStyle.Render("message deleted"))
+	}
+
+	text := msg.Text
+	if msg.Type == core.MessageTypeChat {
+		text = m.highlightMentions(text)
+	}
+	if !msg.EditedAt.IsZero() {
+		text += " " + timeStyle.Render("(edited)")
+	}
+	if len(msg.Reactions) > 0 {
+		text += "\n" + m.reactionsView(msg.Reactions)
+	}
+	return fmt.Sprintf("%s\n%s", header, text)
+}
+
+// renderMessage formats msg wrapped to width, marking it when it is selected
+// or mentions the user.
+func (m *Model) renderMessage(msg core.Message, width int) string {
+	block := m.formatMessage(msg)
+	if msg.Replies > 0 && msg.ParentID == "" {
+		block += "\n" + replyCountStyle.Render(fmt.Sprintf("↳ %d %s", msg.Replies, plural(msg.Replies, "reply", "replies")))
+	}
+
+	frame := lipgloss.NewStyle()
+	switch {
+	case msg.ID == m.selected:
+		frame = selectedStyle
+	case msg.Type == core.MessageTypeChat && msg.UserID != m.session.UserID && msg.Mentioned(m.session.UserID):
+		frame = mentionLineStyle
+	}
+	return frame.Width(max(width-frame.GetHorizontalBorderSize(), 1)).Render(block)
+}
+
+// reactionsView renders reactions as compact counts, marking the ones the
+// user added.
+func (m *Model) reactionsView(reactions []core.Reaction) string {
+	chips := make([]string, 0, len(reactions))
+	for _, r := range reactions {
+		style := reactionStyle
+		if slices.Contains(r.Users, m.session.UserID) {
+			style = reactionOwnStyle
+		}
+		chips = append(chips, style.Render(fmt.Sprintf("%s %d", r.Emoji, len(r.Users))))
+	}
+	return strings.Join(chips, " ")
+}
+
+// highlightMentions colors every @nick in text, and @you more loudly.
+func (m *Model) highlightMentions(text string) string {
+	me := strings.ToLower(m.session.Username())
+
+	var b strings.Builder
+	last := 0
+	for _, span := range core.FindMentions(text) {
+		mention := text[span[0]:span[1]]
+		style := mentionOtherStyle
+		if strings.ToLower(mention[1:]) == me {
+			style = mentionStyle
+		}
+		b.WriteString(text[last:span[0]])
+		b.WriteString(style.Render(mention))
+		last = span[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
 }
 
+// headerView shows the active channel with its topic and modes.
 func (m *Model) headerView() string {
-	headerContent := `
-█▀ █▀ █ █ █▀▀ █ █ ▄▀█ ▀█▀
-▄█ ▄█ █▀█ █▄▄ █▀█ █▀█  █
-`
-	return titleStyle.Render(headerContent)
+	if m.active == "" {
+		return titleStyle.Render("sshchat")
+	}
+
+	title := m.label(m.active)
+	var details []string
+	if core.IsDirect(m.active) {
+		details = append(details, "Direct conversation")
+	} else if m.panes.hasInfo {
+		info := m.panes.info
+		if modes := info.Modes.String(); modes != "" {
+			title += " " + modes
+		}
+		if info.Archived {
+			details = append(details, "(archived)")
+		}
+		if info.Topic != "" {
+			details = append(details, info.Topic)
+		}
+	}
+
+	// The title style's padding goes under the whole line.
+	line := titleStyle.UnsetPaddingBottom().Render(title)
+	if len(details) > 0 {
+		width := m.innerWidth - lipgloss.Width(title) - 1
+		line += " " + topicStyle.Render(truncate(strings.Join(details, " "), width))
+	}
+	return lipgloss.NewStyle().PaddingBottom(titleStyle.GetPaddingBottom()).Render(line)
 }
 
 func (m *Model) statusBar() string {
-	channel := m.session.CurrentChannel
-	if channel == "" {
-		channel = "none"
+	channel := "#none"
+	if m.active != "" {
+		channel = m.label(m.active)
 	}
 
-	left := fmt.Sprintf("#%s | %s", channel, m.session.Username)
-	total := m.viewport.Width
+	if m.thread != nil {
+		channel += " ↳ " + m.thread.root.ShortID()
+	}
+
+	left := fmt.Sprintf("%s | %s", channel, m.session.Username()) + m.unreadIndicators()
+	if m.notice != "" {
+		left += " " + noticeStyle.Render(m.notice)
+	}
+	total := m.innerWidth
 	leftW := lipgloss.Width(left)
 
 	right := ModeStyle(m.input.Mode()).Render(m.input.StatusLabel())
@@ -238,34 +666,56 @@ func (m *Model) statusBar() string {
 	return statusStyle.Copy().Width(total).Render(line)
 }
 
+// unreadIndicators lists the other joined channels and conversations that
+// have unread messages, e.g. " #random 3 @bob 2@".
+func (m *Model) unreadIndicators() string {
+	var b strings.Builder
+	for _, entry := range m.sidebarEntries() {
+		view, ok := m.channels[entry.name]
+		if !ok || entry.name == m.active || view.unread == 0 {
+			continue
+		}
+		label := fmt.Sprintf("%s %d", entry.label, view.unread)
+		style := unreadStyle
+		if view.mentions > 0 {
+			label += "@"
+			style = mentionStyle
+		}
+		b.WriteString(" ")
+		b.WriteString(style.Render(label))
+	}
+	return b.String()
+}
+
 func (m *Model) handleResize(msg tea.WindowSizeMsg) {
 	m.width = msg.Width
 	m.height = msg.Height
 
 	outerHFrame, outerVFrame := appFrameStyle.GetFrameSize()
-	innerWidth := msg.Width - outerHFrame
-	if innerWidth < 0 {
-		innerWidth = 0
-	}
-	innerHeight := msg.Height - outerVFrame
-	if innerHeight < 0 {
-		innerHeight = 0
+	m.innerWidth = max(msg.Width-outerHFrame, 0)
+	m.innerHeight = max(msg.Height-outerVFrame, 0)
+
+	m.layout()
+
+	if !m.ready {
+		m.ready = true
 	}
+}
 
-	m.viewport.Width = innerWidth
+// layout sizes the viewport and input to the inner frame, leaving room for
+// whichever side panes are shown.
+func (m *Model) layout() {
+	m.viewport.Width = max(m.innerWidth-m.paneWidths(), 0)
 
 	headerHeight := lipgloss.Height(m.headerView())
-	statusRendered := m.statusBar()
-	statusHeight := lipgloss.Height(statusRendered)
+	typingHeight := lipgloss.Height(m.typingView())
+	statusHeight := lipgloss.Height(m.statusBar())
 
 	inputHFrame, inputVFrame := inputBoxStyle.GetFrameSize()
-	textAreaWidth := innerWidth - inputHFrame
-	if textAreaWidth < 0 {
-		textAreaWidth = 0
-	}
+	textAreaWidth := max(m.innerWidth-inputHFrame, 0)
 	inputHeight := m.input.InlineHeight() + inputVFrame
 
-	viewportHeight := innerHeight - headerHeight - inputHeight - statusHeight
+	viewportHeight := m.innerHeight - headerHeight - typingHeight - inputHeight - statusHeight
 	if viewportHeight < 3 {
 		viewportHeight = 3
 	}
@@ -273,9 +723,11 @@ func (m *Model) handleResize(msg tea.WindowSizeMsg) {
 	m.input.OnResize(textAreaWidth, viewportHeight, statusHeight, inputVFrame)
 
 	m.updateViewport()
+}
 
-	if !m.ready {
-		m.ready = true
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
 	}
-
+	return many
 }
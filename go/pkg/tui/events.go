@@ -0,0 +1,41 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+// eventRun returns the join, part, quit and nick change events at the start
+// of messages. Thread replies in between do not break the run.
+func eventRun(messages []core.Message) []core.Message {
+	n := 0
+	for i := range messages {
+		if messages[i].ParentID != "" {
+			continue
+		}
+		if !core.IsMembershipEvent(&messages[i]) {
+			break
+		}
+		n = i + 1
+	}
+	return messages[:n]
+}
+
+// collapseEvents folds a run of events into one line stamped with the time
+// of the last, e.g. "alice joined #general · bob quit", wrapped to width.
+func collapseEvents(run []core.Message, width int) string {
+	var texts []string
+	last := run[0]
+	for _, msg := range run {
+		if msg.ParentID == "" {
+			texts = append(texts, msg.Text)
+			last = msg
+		}
+	}
+	timestamp := timeStyle.Render(last.Timestamp.Format("15:04"))
+	block := fmt.Sprintf("%s\n%s", timestamp, timeStyle.Render(strings.Join(texts, " · ")))
+	return lipgloss.NewStyle().Width(max(width, 1)).Render(block)
+}
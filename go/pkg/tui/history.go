@@ -0,0 +1,357 @@
+package tui
+
+import (
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+const (
+	// historyPage is how many older messages are fetched at a time.
+	historyPage = 100
+	// maxViewMessages caps how many messages a channel view keeps, and
+	// separately how many join and quit events. Live messages push out the
+	// oldest; scrolling back pushes out the newest, which load again when
+	// scrolling down.
+	maxViewMessages = 1000
+)
+
+// historyLoaded is the hub's answer to a request for older messages, or for
+// newer ones when newer is set.
+type historyLoaded struct {
+	channel  string
+	messages []*core.Message
+	newer    bool
+	err      error
+}
+
+// windowLoaded is the history around a message the user asked to see.
+type windowLoaded struct {
+	channel string
+	target  string
+	older   []*core.Message
+	newer   []*core.Message
+	err     error
+}
+
+// oldest is the timestamp of the oldest message the view holds for channel.
+func (view *channelView) oldest(channel string) (time.Time, bool) {
+	for _, msg := range view.messages {
+		if msg.ChannelID == channel {
+			return msg.Timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// newest is the timestamp of the newest message the view holds for channel.
+func (view *channelView) newest(channel string) (time.Time, bool) {
+	for i := len(view.messages) - 1; i >= 0; i-- {
+		if view.messages[i].ChannelID == channel {
+			return view.messages[i].Timestamp, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// requestOlder asks the hub for the page of history before what the active
+// channel view holds, unless a request is in flight or there is nothing more
+// to load.
+func (m *Model) requestOlder() tea.Cmd {
+	channel := m.active
+	view := m.channel(channel)
+	if channel == "" || view.loading || view.loadingNewer || view.exhausted {
+		return nil
+	}
+	before, ok := view.oldest(channel)
+	if !ok {
+		return nil
+	}
+
+	view.loading = true
+	m.updateViewport()
+
+	userID := m.session.UserID
+	return func() tea.Msg {
+		messages, err := m.hub.History(userID, channel, before, historyPage)
+		return historyLoaded{channel: channel, messages: messages, err: err}
+	}
+}
+
+// requestNewer asks the hub for the page of history after what the active
+// channel view holds, when the view stops short of the latest messages.
+func (m *Model) requestNewer() tea.Cmd {
+	channel := m.active
+	view := m.channel(channel)
+	if channel == "" || !view.detached || view.loading || view.loadingNewer {
+		return nil
+	}
+	from, ok := view.newest(channel)
+	if !ok {
+		return nil
+	}
+
+	view.loadingNewer = true
+	m.updateViewport()
+
+	userID := m.session.UserID
+	return func() tea.Msg {
+		// The page starts with the newest message the view already holds.
+		messages, err := m.hub.HistoryFrom(userID, channel, from, historyPage+1)
+		return historyLoaded{channel: channel, messages: messages, newer: true, err: err}
+	}
+}
+
+// maybeRequestHistory loads more history when the user has scrolled to the
+// top of the active channel, or to the bottom of a view that stops short of
+// the latest messages.
+func (m *Model) maybeRequestHistory() tea.Cmd {
+	var cmds []tea.Cmd
+	if m.viewport.AtTop() {
+		cmds = append(cmds, m.requestOlder())
+	}
+	if m.viewport.AtBottom() {
+		cmds = append(cmds, m.requestNewer())
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *Model) historyLoaded(loaded historyLoaded) {
+	if loaded.newer {
+		m.newerLoaded(loaded)
+		return
+	}
+
+	view := m.channel(loaded.channel)
+	view.loading = false
+	if loaded.err != nil || len(loaded.messages) < historyPage {
+		view.exhausted = true
+	}
+
+	if loaded.channel != m.active {
+		m.prependOlder(loaded.channel, loaded.messages)
+		return
+	}
+
+	// Keep the messages that were on screen where they were.
+	lines := m.viewport.TotalLineCount()
+	m.prependOlder(loaded.channel, loaded.messages)
+	offset := m.viewport.YOffset
+	m.updateViewport()
+	m.viewport.SetYOffset(offset + m.viewport.TotalLineCount() - lines)
+}
+
+// prependOlder adds older messages in front of the view, skipping ones it
+// already holds, and reports how many it added. Past the cap the newest
+// messages make room, leaving the view detached.
+func (m *Model) prependOlder(channel string, older []*core.Message) int {
+	view := m.channel(channel)
+
+	page := make([]core.Message, 0, len(older)+len(view.messages))
+	for _, msg := range older {
+		if !m.hasMessage(channel, msg.ID) {
+			page = append(page, *msg)
+		}
+	}
+	view.messages = append(page, view.messages...)
+
+	messages, _ := view.counts()
+	end := len(view.messages)
+	for ; messages > maxViewMessages; end-- {
+		if !core.IsMembershipEvent(&view.messages[end-1]) {
+			messages--
+		}
+	}
+	if end < len(view.messages) {
+		view.messages = view.messages[:end]
+		view.detached = true
+	}
+	return len(page)
+}
+
+func (m *Model) newerLoaded(loaded historyLoaded) {
+	view := m.channel(loaded.channel)
+	view.loadingNewer = false
+	if loaded.err != nil {
+		if loaded.channel == m.active {
+			m.updateViewport()
+		}
+		return
+	}
+
+	var newer []core.Message
+	for _, msg := range loaded.messages {
+		if !m.hasMessage(loaded.channel, msg.ID) {
+			newer = append(newer, *msg)
+		}
+	}
+	if len(loaded.messages) <= historyPage {
+		// Caught up: from here on live messages are added as they arrive,
+		// starting with those that came in while this page was on its way.
+		view.detached = false
+		for _, msg := range view.pending {
+			if !slices.ContainsFunc(newer, func(n core.Message) bool { return n.ID == msg.ID }) {
+				newer = append(newer, msg)
+			}
+		}
+		view.pending = nil
+	}
+	m.appendNewer(loaded.channel, newer)
+}
+
+// appendNewer adds newer messages to the end of the view, dropping the
+// oldest past the cap, without moving what is on screen.
+func (m *Model) appendNewer(channel string, newer []core.Message) {
+	view := m.channel(channel)
+	view.messages = append(view.messages, newer...)
+	if channel != m.active {
+		view.trim()
+		return
+	}
+
+	offset := m.viewport.YOffset
+	m.updateViewport()
+	lines := m.viewport.TotalLineCount()
+	view.trim()
+	m.updateViewport()
+	m.viewport.SetYOffset(offset - (lines - m.viewport.TotalLineCount()))
+}
+
+// requestWindow asks the hub for the history around msg, to show it in a
+// view that does not hold it.
+func (m *Model) requestWindow(msg core.Message) tea.Cmd {
+	view := m.channel(msg.ChannelID)
+	view.loading = true
+	m.updateViewport()
+
+	userID := m.session.UserID
+	return func() tea.Msg {
+		window := windowLoaded{channel: msg.ChannelID, target: msg.ID}
+		window.older, window.err = m.hub.History(userID, msg.ChannelID, msg.Timestamp, historyPage/2)
+		if window.err == nil {
+			window.newer, window.err = m.hub.HistoryFrom(userID, msg.ChannelID, msg.Timestamp, historyPage/2)
+		}
+		return window
+	}
+}
+
+func (m *Model) windowLoaded(window windowLoaded) {
+	view := m.channel(window.channel)
+	view.loading = false
+	if window.err != nil {
+		if window.channel == m.active {
+			m.updateViewport()
+		}
+		return
+	}
+
+	oldest, ok := view.oldest(window.channel)
+	overlaps := ok && slices.ContainsFunc(window.newer, func(msg *core.Message) bool {
+		return m.hasMessage(window.channel, msg.ID)
+	})
+
+	if overlaps {
+		// The window reaches what the view holds, so it extends it.
+		var older []*core.Message
+		for _, msg := range append(window.older, window.newer...) {
+			if msg.Timestamp.Before(oldest) {
+				older = append(older, msg)
+			}
+		}
+		m.prependOlder(window.channel, older)
+	} else {
+		// Too far back to join up: show the window on its own, and load
+		// newer messages again as the user scrolls down.
+		held := view.messages
+		view.messages = nil
+		for _, msg := range append(window.older, window.newer...) {
+			view.messages = append(view.messages, *msg)
+		}
+		view.exhausted = len(window.older) < historyPage/2
+		view.detached = len(window.newer) == historyPage/2
+		view.pending = nil
+
+		if !view.detached {
+			// Keep what arrived while the window was on its way.
+			newest, _ := view.newest(window.channel)
+			for _, msg := range held {
+				if msg.ChannelID == window.channel && msg.Timestamp.After(newest) {
+					view.messages = append(view.messages, msg)
+				}
+			}
+		}
+	}
+
+	if window.channel != m.active {
+		return
+	}
+	if m.hasMessage(window.channel, window.target) {
+		m.selected = window.target
+	}
+	m.updateViewport()
+}
+
+// marker is the line shown above the oldest message of the view.
+func (view *channelView) marker() string {
+	switch {
+	case view.loading:
+		return "Loading older messages…"
+	case view.exhausted:
+		return "Beginning of history"
+	}
+	return ""
+}
+
+// footer is the line shown below the newest message of the view.
+func (view *channelView) footer() string {
+	switch {
+	case view.loadingNewer:
+		return "Loading newer messages…"
+	case view.detached:
+		return "Newer messages load as you scroll down"
+	}
+	return ""
+}
+
+// counts returns how many messages and how many join and quit events the
+// view holds.
+func (view *channelView) counts() (messages, events int) {
+	for i := range view.messages {
+		if core.IsMembershipEvent(&view.messages[i]) {
+			events++
+		} else {
+			messages++
+		}
+	}
+	return messages, events
+}
+
+// trim drops the oldest messages once a view grows past the cap. They can be
+// loaded again by scrolling back. Join and quit events have a cap of their
+// own, so a flood of them only pushes out older events.
+func (view *channelView) trim() {
+	messages, events := view.counts()
+	overMessages := max(messages-maxViewMessages, 0)
+	overEvents := max(events-maxViewMessages, 0)
+	if overMessages == 0 && overEvents == 0 {
+		return
+	}
+
+	kept := make([]core.Message, 0, len(view.messages)-overMessages-overEvents)
+	for _, msg := range view.messages {
+		switch {
+		case core.IsMembershipEvent(&msg) && overEvents > 0:
+			overEvents--
+		case !core.IsMembershipEvent(&msg) && overMessages > 0:
+			overMessages--
+		default:
+			kept = append(kept, msg)
+		}
+	}
+	if messages > maxViewMessages {
+		view.exhausted = false
+	}
+	view.messages = kept
+}
@@ -0,0 +1,52 @@
+package tui
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+func TestTrimKeepsMessagesPastJoinFlood(t *testing.T) {
+	view := &channelView{}
+	for i := range 10 {
+		view.messages = append(view.messages, *core.NewMessage(core.MessageTypeChat, "general", "u1", "alice", fmt.Sprintf("hello %d", i)))
+	}
+	for range maxViewMessages + 5 {
+		view.messages = append(view.messages, *core.NewMessage(core.MessageTypeJoin, "general", "u2", "bob", "bob joined #general"))
+	}
+
+	view.trim()
+
+	messages, events := view.counts()
+	if messages != 10 {
+		t.Errorf("kept %d chat messages, want 10", messages)
+	}
+	if events != maxViewMessages {
+		t.Errorf("kept %d events, want %d", events, maxViewMessages)
+	}
+	for i, msg := range view.messages[:10] {
+		if want := fmt.Sprintf("hello %d", i); msg.Text != want {
+			t.Errorf("message %d is %q, want %q", i, msg.Text, want)
+		}
+	}
+}
+
+func TestTrimDropsOldestMessages(t *testing.T) {
+	view := &channelView{exhausted: true}
+	for i := range maxViewMessages + 3 {
+		view.messages = append(view.messages, *core.NewMessage(core.MessageTypeChat, "general", "u1", "alice", fmt.Sprintf("hello %d", i)))
+	}
+
+	view.trim()
+
+	if len(view.messages) != maxViewMessages {
+		t.Fatalf("kept %d messages, want %d", len(view.messages), maxViewMessages)
+	}
+	if view.messages[0].Text != "hello 3" {
+		t.Errorf("oldest kept message is %q, want %q", view.messages[0].Text, "hello 3")
+	}
+	if view.exhausted {
+		t.Error("view still marked exhausted after dropping history")
+	}
+}
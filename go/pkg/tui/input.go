@@ -2,6 +2,7 @@ package tui
 
 import (
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -25,8 +26,15 @@ type InputController struct {
 	cmd        textinput.Model
 	cmdCurrent string
 	lastW      int
+	replyTo    string
+	lastTouch  time.Time
+	lastValue  string
+	lastTyping time.Time
 }
 
+// touchInterval throttles how often key presses are reported as activity.
+const touchInterval = 10 * time.Second
+
 func NewInputController() *InputController {
 	ta := textarea.New()
 	configureTextarea(&ta)
@@ -64,6 +72,14 @@ func (i *InputController) Update(msg tea.Msg) tea.Cmd {
 	return nil
 }
 
+// Activity reports a key press to the hub so the user does not show as idle.
+func (i *InputController) Activity(session *core.Session) {
+	if now := time.Now(); now.Sub(i.lastTouch) >= touchInterval {
+		i.lastTouch = now
+		session.Touch()
+	}
+}
+
 func (i *InputController) HandleKey(k tea.KeyMsg, session *core.Session) (tea.Cmd, bool) {
 	if k.Type == tea.KeyCtrlC {
 		return nil, false
@@ -120,7 +136,7 @@ func (i *InputController) HandleKey(k tea.KeyMsg, session *core.Session) (tea.Cm
 				return nil, true
 			}
 			if text != "" {
-				session.SendMessage(text)
+				session.SendReply(i.replyTo, text)
 				i.ta.Reset()
 			}
 			return nil, true
@@ -171,6 +187,23 @@ func (i *InputController) Mode() Mode {
 	return i.mode
 }
 
+// SetReplyTo makes plain messages replies to parentID. An empty parentID
+// sends them to the channel again.
+func (i *InputController) SetReplyTo(parentID string) {
+	i.replyTo = parentID
+}
+
+// Prefill puts text in the input and switches to Insert mode so the user can
+// finish it.
+func (i *InputController) Prefill(text string) tea.Cmd {
+	i.ta.SetValue(text)
+	return i.enterInsert()
+}
+
+func (i *InputController) EnterNormal() {
+	i.enterNormal()
+}
+
 func (i *InputController) EnterInsert() tea.Cmd {
 	return i.enterInsert()
 }
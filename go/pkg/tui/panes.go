@@ -0,0 +1,296 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+const (
+	sidebarWidth = 22
+	membersWidth = 22
+	// minChatWidth keeps the message area usable; panes that would squeeze
+	// it below this are not drawn.
+	minChatWidth = 30
+)
+
+// sidebar lists joined channels. In Normal mode tab focuses it, j/k move
+// the cursor and enter switches to the selected channel.
+type sidebar struct {
+	visible bool
+	focused bool
+	cursor  int
+}
+
+type sidebarEntry struct {
+	name   string
+	label  string
+	peer   string
+	direct bool
+}
+
+// paneState is the hub state shown in the panes and the header. It is
+// fetched when the hub reports a change rather than on every render.
+type paneState struct {
+	entries []sidebarEntry
+	members []core.Member
+	info    core.ChannelInfo
+	hasInfo bool
+}
+
+// refreshPanes takes a new snapshot of the state shown in the panes.
+func (m *Model) refreshPanes() {
+	m.panes.entries = m.loadSidebarEntries()
+	m.panes.members = nil
+	m.panes.hasInfo = false
+	if core.IsDirect(m.active) {
+		m.panes.members = m.directMembers()
+	} else if m.active != "" {
+		m.panes.members = m.hub.ChannelMembers(m.active)
+		m.panes.info, m.panes.hasInfo = m.hub.ChannelInfo(m.active)
+	}
+}
+
+// sidebarEntries lists joined channels followed by direct conversations, as
+// of the last refresh.
+func (m *Model) sidebarEntries() []sidebarEntry {
+	return m.panes.entries
+}
+
+func (m *Model) hasEntry(name string) bool {
+	for _, entry := range m.panes.entries {
+		if entry.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Model) loadSidebarEntries() []sidebarEntry {
+	user := m.session.User()
+	if user == nil {
+		return nil
+	}
+
+	var entries []sidebarEntry
+	for _, name := range user.Channels() {
+		entries = append(entries, sidebarEntry{name: name, label: "#" + name})
+	}
+	for _, chat := range m.hub.DirectChats(m.session.UserID) {
+		entries = append(entries, sidebarEntry{name: chat.ID, label: "@" + chat.Peer, peer: chat.Peer, direct: true})
+	}
+	return entries
+}
+
+// label is how the channel or conversation name is shown to the user.
+func (m *Model) label(name string) string {
+	if !core.IsDirect(name) {
+		return "#" + name
+	}
+	for _, entry := range m.sidebarEntries() {
+		if entry.name == name {
+			return entry.label
+		}
+	}
+	return "@?"
+}
+
+func (m *Model) sidebarShown() bool {
+	return m.sidebar.visible && m.innerWidth-sidebarWidth >= minChatWidth
+}
+
+func (m *Model) membersShown() bool {
+	width := m.innerWidth - membersWidth
+	if m.sidebarShown() {
+		width -= sidebarWidth
+	}
+	return m.showMembers && !m.threadShown() && !m.searchShown() && width >= minChatWidth
+}
+
+func (m *Model) paneWidths() int {
+	width := 0
+	if m.sidebarShown() {
+		width += sidebarWidth
+	}
+	if m.threadShown() || m.searchShown() {
+		width += m.threadWidth()
+	}
+	if m.membersShown() {
+		width += membersWidth
+	}
+	return width
+}
+
+// handlePaneKey handles the Normal mode keys that toggle and drive the side
+// panes.
+func (m *Model) handlePaneKey(k tea.KeyMsg) (tea.Cmd, bool) {
+	switch k.String() {
+	case "b":
+		m.sidebar.visible = !m.sidebar.visible
+		m.sidebar.focused = m.sidebar.focused && m.sidebar.visible
+		m.layout()
+		return nil, true
+	case "u":
+		m.showMembers = !m.showMembers
+		m.layout()
+		return nil, true
+	case "tab":
+		if m.sidebarShown() {
+			m.sidebar.focused = !m.sidebar.focused
+			m.sidebar.cursor = m.activeEntryIndex()
+		}
+		return nil, true
+	}
+
+	if !m.sidebar.focused {
+		if k.String() == "esc" && m.thread != nil {
+			m.closeThread()
+			return nil, true
+		}
+		return nil, false
+	}
+
+	entries := m.sidebarEntries()
+	switch k.String() {
+	case "j", "down":
+		if m.sidebar.cursor < len(entries)-1 {
+			m.sidebar.cursor++
+		}
+	case "k", "up":
+		if m.sidebar.cursor > 0 {
+			m.sidebar.cursor--
+		}
+	case "enter":
+		if m.sidebar.cursor < len(entries) {
+			entry := entries[m.sidebar.cursor]
+			if entry.direct {
+				m.session.SendCommand(core.Command{Name: "query", Args: []string{entry.peer}})
+			} else {
+				m.session.SendCommand(core.Command{Name: "join", Args: []string{entry.name}})
+			}
+		}
+		m.sidebar.focused = false
+	case "esc":
+		m.sidebar.focused = false
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+func (m *Model) activeEntryIndex() int {
+	for i, entry := range m.sidebarEntries() {
+		if entry.name == m.active {
+			return i
+		}
+	}
+	return 0
+}
+
+func (m *Model) sidebarView() string {
+	var lines []string
+	lines = append(lines, paneTitleStyle.Render("Channels"))
+
+	entries := m.sidebarEntries()
+	for i, entry := range entries {
+		if entry.direct && (i == 0 || !entries[i-1].direct) {
+			lines = append(lines, "", paneTitleStyle.Render("Direct"))
+		}
+
+		label := entry.label
+		style := paneItemStyle
+		if entry.name == m.active {
+			style = paneActiveStyle
+		}
+
+		if view, ok := m.channels[entry.name]; ok && entry.name != m.active && view.unread > 0 {
+			badge := unreadStyle
+			count := fmt.Sprintf("%d", view.unread)
+			if view.mentions > 0 {
+				badge = mentionStyle
+				count += "@"
+			}
+			label = truncate(label, sidebarWidth-4-len(count)) + " " + badge.Render(count)
+		} else {
+			label = truncate(label, sidebarWidth-3)
+		}
+
+		if m.sidebar.focused && i == m.sidebar.cursor {
+			style = paneCursorStyle
+		}
+		lines = append(lines, style.Render(label))
+	}
+
+	return sidebarStyle.
+		Width(sidebarWidth - sidebarStyle.GetHorizontalBorderSize()).
+		Height(m.viewport.Height).
+		Render(strings.Join(lines, "\n"))
+}
+
+func (m *Model) membersView() string {
+	var lines []string
+	members := m.panes.members
+	lines = append(lines, paneTitleStyle.Render(fmt.Sprintf("Members (%d)", len(members))))
+
+	for _, member := range members {
+		name := truncate(member.Role.Prefix()+member.Username, membersWidth-5)
+		lines = append(lines, presenceDot(member.Presence)+" "+UserStyle(member.UserID).UnsetBold().Render(name))
+		if member.Presence == core.PresenceAway && member.Status != "away" {
+			lines = append(lines, "  "+timeStyle.Render(truncate(member.Status, membersWidth-5)))
+		}
+	}
+
+	return membersStyle.
+		Width(membersWidth - membersStyle.GetHorizontalBorderSize()).
+		Height(m.viewport.Height).
+		Render(strings.Join(lines, "\n"))
+}
+
+func presenceDot(p core.Presence) string {
+	switch p {
+	case core.PresenceOnline:
+		return presenceOnlineStyle.Render("●")
+	case core.PresenceIdle:
+		return presenceIdleStyle.Render("◐")
+	case core.PresenceAway:
+		return presenceIdleStyle.Render("○")
+	}
+	return presenceOfflineStyle.Render("○")
+}
+
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if lipgloss.Width(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// directMembers shows both sides of the active conversation in the member
+// pane.
+func (m *Model) directMembers() []core.Member {
+	self := core.Member{UserID: m.session.UserID, Username: m.session.Username(), Online: true}
+	self.Presence, self.Status = m.hub.Presence(m.session.UserID)
+	members := []core.Member{self}
+	for _, chat := range m.hub.DirectChats(m.session.UserID) {
+		if chat.ID == m.active && chat.PeerID != m.session.UserID {
+			members = append(members, core.Member{
+				UserID:   chat.PeerID,
+				Username: chat.Peer,
+				Online:   chat.Online,
+				Presence: chat.Presence,
+				Status:   chat.Status,
+			})
+		}
+	}
+	return members
+}
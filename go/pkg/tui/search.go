@@ -0,0 +1,162 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+// searchView holds the results of a /search shown in the right pane. While
+// it is open, j/k move through the results and Enter jumps to one.
+type searchView struct {
+	query   string
+	results []core.Message
+	cursor  int
+	err     string
+}
+
+func (m *Model) openSearch(query string) {
+	search := &searchView{query: query}
+	results, err := m.hub.Search(m.session.UserID, query)
+	if err != nil {
+		search.err = err.Error()
+	}
+	for _, msg := range results {
+		search.results = append(search.results, *msg)
+	}
+
+	m.search = search
+	m.input.EnterNormal()
+	m.layout()
+}
+
+func (m *Model) closeSearch() {
+	m.search = nil
+	m.layout()
+}
+
+func (m *Model) searchShown() bool {
+	width := m.innerWidth - m.threadWidth()
+	if m.sidebarShown() {
+		width -= sidebarWidth
+	}
+	return m.search != nil && width >= minChatWidth
+}
+
+func (m *Model) handleSearchKey(k tea.KeyMsg) (tea.Cmd, bool) {
+	if m.search == nil {
+		return nil, false
+	}
+
+	switch k.String() {
+	case "j", "down":
+		if m.search.cursor < len(m.search.results)-1 {
+			m.search.cursor++
+		}
+	case "k", "up":
+		if m.search.cursor > 0 {
+			m.search.cursor--
+		}
+	case "enter":
+		if m.search.cursor < len(m.search.results) {
+			return m.jump(m.search.results[m.search.cursor]), true
+		}
+	case "esc":
+		m.closeSearch()
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// jump shows msg in its channel, switching to the channel first if needed.
+// Results from channels the user is not in are only listed: opening them
+// would join the channel behind the user's back.
+func (m *Model) jump(msg core.Message) tea.Cmd {
+	if msg.ChannelID == m.active {
+		return m.reveal(msg)
+	}
+
+	for _, entry := range m.sidebarEntries() {
+		if entry.name != msg.ChannelID {
+			continue
+		}
+		m.pendingJump = &msg
+		if entry.direct {
+			m.session.SendCommand(core.Command{Name: "query", Args: []string{entry.peer}})
+		} else {
+			m.session.SendCommand(core.Command{Name: "join", Args: []string{entry.name}})
+		}
+		return nil
+	}
+
+	m.pendingJump = nil
+	if core.IsDirect(msg.ChannelID) {
+		m.notice = "Reopen the conversation with /query to see this message"
+	} else {
+		m.notice = fmt.Sprintf("Type /join #%s to see this message", msg.ChannelID)
+	}
+	return nil
+}
+
+// reveal selects msg in the active channel. When the view does not hold it,
+// the history around it is loaded in its place.
+func (m *Model) reveal(msg core.Message) tea.Cmd {
+	m.pendingJump = nil
+	if !m.hasMessage(m.active, msg.ID) {
+		return m.requestWindow(msg)
+	}
+	m.selected = msg.ID
+	m.updateViewport()
+	return nil
+}
+
+func (m *Model) hasMessage(channel, id string) bool {
+	for _, msg := range m.channel(channel).messages {
+		if msg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Model) searchPaneView() string {
+	style := threadPaneStyle
+	width := m.threadWidth() - style.GetHorizontalFrameSize()
+
+	var lines []string
+	lines = append(lines, paneTitleStyle.Render(fmt.Sprintf("Search (%d)", len(m.search.results))))
+	switch {
+	case m.search.err != "":
+		lines = append(lines, errorTextStyle.Render(m.search.err))
+	case len(m.search.results) == 0:
+		lines = append(lines, timeStyle.Render("No messages match "+m.search.query))
+	}
+
+	// Keep the cursor in view by starting from the page it is on.
+	perResult := 3
+	perPage := max((m.viewport.Height-2)/perResult, 1)
+	start := m.search.cursor / perPage * perPage
+
+	for i := start; i < len(m.search.results) && i < start+perPage; i++ {
+		msg := m.search.results[i]
+		where := m.label(msg.ChannelID)
+		header := fmt.Sprintf("%s %s %s", where, UserStyle(msg.UserID).Render(msg.Username),
+			timeStyle.Render(msg.Timestamp.Format("Jan 2 15:04")))
+		text := truncate(strings.ReplaceAll(msg.Text, "\n", " "), width-2)
+
+		if i == m.search.cursor {
+			header = paneCursorStyle.Render(lipgloss.NewStyle().Width(width).Render(fmt.Sprintf("%s %s %s",
+				where, msg.Username, msg.Timestamp.Format("Jan 2 15:04"))))
+		}
+		lines = append(lines, header, "  "+text, "")
+	}
+
+	return style.
+		Width(m.threadWidth() - style.GetHorizontalBorderSize()).
+		Height(m.viewport.Height).
+		Render(strings.Join(lines, "\n"))
+}
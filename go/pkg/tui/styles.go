@@ -28,6 +28,9 @@ var (
 			Foreground(lipgloss.Color(colorPeach)).
 			PaddingBottom(1)
 
+	topicStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(colorRosewater))
+
 	timeStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color(textMuted))
 
@@ -43,6 +46,86 @@ var (
 	statusStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color(colorWhite))
 
+	unreadStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(colorYellow))
+
+	mentionStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color(colorRed))
+
+	tombstoneStyle = lipgloss.NewStyle().
+			Italic(true).
+			Foreground(lipgloss.Color(textMuted))
+
+	mentionOtherStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color(colorBlue))
+
+	mentionLineStyle = lipgloss.NewStyle().
+				Border(lipgloss.ThickBorder(), false, false, false, true).
+				BorderForeground(lipgloss.Color(colorRed)).
+				PaddingLeft(1)
+
+	replyCountStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(colorBlue))
+
+	threadPaneStyle = lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder(), false, false, false, true).
+			BorderForeground(lipgloss.Color(textMuted)).
+			PaddingLeft(1)
+
+	reactionStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(textMuted))
+
+	reactionOwnStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color(colorPeach))
+
+	selectedStyle = lipgloss.NewStyle().
+			Border(lipgloss.ThickBorder(), false, false, false, true).
+			BorderForeground(lipgloss.Color(colorPeach)).
+			PaddingLeft(1)
+
+	noticeStyle = lipgloss.NewStyle().
+			Italic(true).
+			Foreground(lipgloss.Color(colorGreen))
+
+	errorTextStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(colorRed))
+
+	sidebarStyle = lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder(), false, true, false, false).
+			BorderForeground(lipgloss.Color(textMuted)).
+			PaddingRight(1)
+
+	membersStyle = lipgloss.NewStyle().
+			Border(lipgloss.NormalBorder(), false, false, false, true).
+			BorderForeground(lipgloss.Color(textMuted)).
+			PaddingLeft(1)
+
+	paneTitleStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color(colorPeach)).
+			PaddingBottom(1)
+
+	paneItemStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color(colorWhite))
+
+	paneActiveStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color(colorPeach))
+
+	paneCursorStyle = lipgloss.NewStyle().
+			Background(lipgloss.Color(colorPeach)).
+			Foreground(lipgloss.Color("#000000"))
+
+	presenceOnlineStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color(colorGreen))
+
+	presenceIdleStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color(colorYellow))
+
+	presenceOfflineStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color(textMuted))
+
 	appFrameStyle = lipgloss.NewStyle().PaddingBottom(1).PaddingLeft(1).PaddingRight(1)
 )
 
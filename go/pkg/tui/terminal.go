@@ -0,0 +1,25 @@
+package tui
+
+import (
+	"io"
+	"sync"
+)
+
+// Terminal is a client's terminal shared by the renderer and the model. The
+// renderer writes each frame in one call, so serializing writes keeps escape
+// sequences the model sends itself, like the bell or a clipboard update, from
+// landing in the middle of a frame.
+type Terminal struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func NewTerminal(w io.Writer) *Terminal {
+	return &Terminal{w: w}
+}
+
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.w.Write(p)
+}
@@ -0,0 +1,95 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+const minThreadWidth = 32
+
+// threadView is an open thread shown in the right pane. While it is open,
+// plain messages are sent as replies to root.
+type threadView struct {
+	channel string
+	root    core.Message
+	replies []core.Message
+}
+
+func (m *Model) openThread(channel, rootID string) {
+	root, replies := m.hub.Thread(channel, rootID)
+	if root == nil {
+		return
+	}
+
+	thread := &threadView{channel: channel, root: *root}
+	for _, reply := range replies {
+		thread.replies = append(thread.replies, *reply)
+	}
+	m.thread = thread
+	m.input.SetReplyTo(root.ID)
+	m.layout()
+}
+
+func (m *Model) closeThread() {
+	m.thread = nil
+	m.input.SetReplyTo("")
+	m.layout()
+}
+
+func (m *Model) threadShown() bool {
+	width := m.innerWidth - m.threadWidth()
+	if m.sidebarShown() {
+		width -= sidebarWidth
+	}
+	return m.thread != nil && m.thread.channel == m.active && m.search == nil && width >= minChatWidth
+}
+
+func (m *Model) threadWidth() int {
+	return max(m.innerWidth/3, minThreadWidth)
+}
+
+// countReply bumps the reply count of reply's parent in the channel view and
+// adds the reply to the open thread.
+func (m *Model) countReply(view *channelView, reply core.Message) {
+	for i := len(view.messages) - 1; i >= 0; i-- {
+		if view.messages[i].ID == reply.ParentID {
+			view.messages[i].Replies++
+			break
+		}
+	}
+	if m.thread != nil && m.thread.root.ID == reply.ParentID {
+		m.thread.root.Replies++
+		m.thread.replies = append(m.thread.replies, reply)
+	}
+}
+
+func (m *Model) threadPaneView() string {
+	style := threadPaneStyle
+	width := m.threadWidth() - style.GetHorizontalFrameSize()
+
+	var b strings.Builder
+	b.WriteString(paneTitleStyle.Render(fmt.Sprintf("Thread (%d)", len(m.thread.replies))))
+	b.WriteString("\n")
+	b.WriteString(m.renderMessage(m.thread.root, width))
+	b.WriteString("\n")
+	b.WriteString(timeStyle.Render(strings.Repeat("─", width)))
+	for _, reply := range m.thread.replies {
+		b.WriteString("\n")
+		b.WriteString(m.renderMessage(reply, width))
+		b.WriteString("\n")
+	}
+
+	// Keep the newest replies in view.
+	lines := strings.Split(lipgloss.NewStyle().Width(width).Render(b.String()), "\n")
+	if len(lines) > m.viewport.Height {
+		lines = lines[len(lines)-m.viewport.Height:]
+	}
+
+	return style.
+		Width(m.threadWidth() - style.GetHorizontalBorderSize()).
+		Height(m.viewport.Height).
+		Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,100 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/frikkfelix/sshchat/go/pkg/core"
+)
+
+const (
+	// typingInterval throttles how often typing is reported while the
+	// input keeps changing.
+	typingInterval = 3 * time.Second
+	// typingTimeout is how long someone shows as typing after their last
+	// typing event.
+	typingTimeout = 5 * time.Second
+)
+
+// typingExpired asks for a redraw once typing indicators may have run out.
+type typingExpired struct{}
+
+type typist struct {
+	name  string
+	until time.Time
+}
+
+// EmitTyping reports typing to the hub when the input changed since the last
+// call, at most once per typingInterval. Commands are not reported.
+func (i *InputController) EmitTyping(session *core.Session) {
+	value := i.ta.Value()
+	changed := value != i.lastValue
+	i.lastValue = value
+
+	text := strings.TrimSpace(value)
+	if !changed || text == "" || strings.HasPrefix(text, "/") || strings.HasPrefix(text, ":") {
+		return
+	}
+	if now := time.Now(); now.Sub(i.lastTyping) >= typingInterval {
+		i.lastTyping = now
+		session.SendTyping()
+	}
+}
+
+// noteTyping records a typing event and schedules the redraw that clears it.
+func (m *Model) noteTyping(msg core.Message) tea.Cmd {
+	typists, ok := m.typing[msg.ChannelID]
+	if !ok {
+		typists = make(map[string]typist)
+		m.typing[msg.ChannelID] = typists
+	}
+	typists[msg.UserID] = typist{name: msg.Username, until: time.Now().Add(typingTimeout)}
+
+	return tea.Tick(typingTimeout, func(time.Time) tea.Msg { return typingExpired{} })
+}
+
+// stopTyping clears the indicator of whoever sent msg, which they are done
+// typing.
+func (m *Model) stopTyping(msg core.Message) {
+	delete(m.typing[msg.ChannelID], msg.UserID)
+}
+
+// pruneTyping forgets typing indicators that ran out.
+func (m *Model) pruneTyping() {
+	now := time.Now()
+	for _, typists := range m.typing {
+		for id, t := range typists {
+			if now.After(t.until) {
+				delete(typists, id)
+			}
+		}
+	}
+}
+
+// typingView is the line above the input, e.g. "alice is typing…".
+func (m *Model) typingView() string {
+	now := time.Now()
+	var names []string
+	for _, t := range m.typing[m.active] {
+		if now.Before(t.until) {
+			names = append(names, t.name)
+		}
+	}
+	sort.Strings(names)
+
+	var text string
+	switch len(names) {
+	case 0:
+		return " "
+	case 1:
+		text = names[0] + " is typing…"
+	case 2:
+		text = names[0] + " and " + names[1] + " are typing…"
+	default:
+		text = fmt.Sprintf("%d people are typing…", len(names))
+	}
+	return " " + timeStyle.Render(truncate(text, m.innerWidth-1))
+}